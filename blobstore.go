@@ -0,0 +1,135 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// BlobStore keeps fetched bodies addressed by their SHA-256 hash
+type BlobStore interface {
+	Put(hash string, data []byte) error
+	Get(hash string) ([]byte, error)
+}
+
+// FileBlobStore stores blobs on disk as dir/ab/abcdef..., optionally
+// zstd-compressed with a .zst suffix. Blobs are immutable once written.
+type FileBlobStore struct {
+	dir      string
+	compress bool
+	encoder  *zstd.Encoder
+	decoder  *zstd.Decoder
+}
+
+// NewFileBlobStore creates a blob store rooted at dir
+func NewFileBlobStore(dir string, compress bool) (*FileBlobStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create blob directory: %w", err)
+	}
+
+	encoder, err := zstd.NewWriter(nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create zstd encoder: %w", err)
+	}
+	decoder, err := zstd.NewReader(nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create zstd decoder: %w", err)
+	}
+
+	return &FileBlobStore{
+		dir:      dir,
+		compress: compress,
+		encoder:  encoder,
+		decoder:  decoder,
+	}, nil
+}
+
+// path returns the location of a blob, without any compression suffix
+func (f *FileBlobStore) path(hash string) (string, error) {
+	if len(hash) != sha256.Size*2 {
+		return "", fmt.Errorf("invalid blob hash %q", hash)
+	}
+	if _, err := hex.DecodeString(hash); err != nil {
+		return "", fmt.Errorf("invalid blob hash %q", hash)
+	}
+	return filepath.Join(f.dir, hash[:2], hash), nil
+}
+
+// Put stores data under hash unless a blob with that hash already exists
+func (f *FileBlobStore) Put(hash string, data []byte) error {
+	path, err := f.path(hash)
+	if err != nil {
+		return err
+	}
+
+	// Either form of an existing blob already holds this content
+	for _, candidate := range []string{path + ".zst", path} {
+		if _, err := os.Stat(candidate); err == nil {
+			return nil
+		}
+	}
+
+	if f.compress {
+		path += ".zst"
+		data = f.encoder.EncodeAll(data, nil)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create blob directory: %w", err)
+	}
+
+	// Write then rename so a crash never leaves a truncated blob behind
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".blob-*")
+	if err != nil {
+		return fmt.Errorf("failed to create blob: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write blob: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write blob: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to store blob: %w", err)
+	}
+
+	return nil
+}
+
+// Get returns the blob stored under hash, verifying its content against
+// the hash. A missing blob is reported with an error wrapping os.ErrNotExist.
+func (f *FileBlobStore) Get(hash string) ([]byte, error) {
+	path, err := f.path(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path + ".zst")
+	if err == nil {
+		data, err = f.decoder.DecodeAll(data, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress blob %s: %w", hash, err)
+		}
+	} else if errors.Is(err, os.ErrNotExist) {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read blob %s: %w", hash, err)
+	}
+
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != hash {
+		return nil, fmt.Errorf("blob %s is corrupt: content hash mismatch", hash)
+	}
+
+	return data, nil
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// commands lists the subcommands accepted on the command line
+var commands = map[string]bool{
+	"report": true,
+	"diff":   true,
+	"db":     true,
+	"notify": true,
+}
+
+// parseArgs splits the command line into the config path, an optional
+// subcommand and its arguments. The config path may be omitted:
+//
+//	legitrack [config.yaml] [command [args...]]
+func parseArgs(args []string) (configPath, command string, cmdArgs []string) {
+	configPath = "config.yaml"
+	if len(args) > 0 && !commands[args[0]] {
+		configPath = args[0]
+		args = args[1:]
+	}
+	if len(args) > 0 {
+		command = args[0]
+		cmdArgs = args[1:]
+	}
+	return configPath, command, cmdArgs
+}
+
+// runDiffCommand prints the text diff between two stored versions of a page
+// of a source:
+//
+//	legitrack diff <source> [--url <url>] [from] [to]
+//
+// Versions are referenced by hash prefix or by date (YYYY-MM-DD, meaning the
+// last version fetched that day). By default the latest version is compared
+// with the one before it of the same page. Sources with several pages, such
+// as sitemap, crawl and feed sources, can be narrowed to one with --url;
+// two versions of different pages are never compared.
+func runDiffCommand(ctx context.Context, storage Storage, config *Config, args []string) error {
+	usage := fmt.Errorf("usage: legitrack diff <source> [--url <url>] [from] [to]")
+
+	var pageURL string
+	var refs []string
+	for rest := args; len(rest) > 0; rest = rest[1:] {
+		if rest[0] == "--url" {
+			if len(rest) < 2 {
+				return usage
+			}
+			pageURL = rest[1]
+			rest = rest[1:]
+			continue
+		}
+		refs = append(refs, rest[0])
+	}
+	if len(refs) < 1 || len(refs) > 3 {
+		return usage
+	}
+	sourceID := refs[0]
+
+	versions, err := storage.GetUpdatesBySource(ctx, sourceID, 0)
+	if err != nil {
+		return err
+	}
+	if pageURL != "" {
+		versions = versionsOf(versions, pageURL)
+	}
+	if len(versions) == 0 {
+		if pageURL != "" {
+			return fmt.Errorf("no stored versions of %s for %s", pageURL, sourceID)
+		}
+		return fmt.Errorf("no stored versions for %s", sourceID)
+	}
+
+	// versions is newest first
+	toIdx := 0
+	if len(refs) == 3 {
+		if toIdx, err = resolveVersion(versions, refs[2]); err != nil {
+			return err
+		}
+	}
+	to := &versions[toIdx]
+
+	// The version compared with is one of the same page
+	page := versionsOf(versions, to.URL)
+	var from *Update
+	if len(refs) >= 2 {
+		fromIdx, err := resolveVersion(page, refs[1])
+		if err != nil {
+			if other, otherErr := resolveVersion(versions, refs[1]); otherErr == nil {
+				return fmt.Errorf("version %s is of %s, not %s; pass --url to choose the page",
+					refs[1], versions[other].URL, to.URL)
+			}
+			return err
+		}
+		from = &page[fromIdx]
+	} else {
+		fromIdx := len(versionsOf(versions[:toIdx], to.URL)) + 1
+		if fromIdx >= len(page) {
+			return fmt.Errorf("%s has no version of %s before %s to compare with", sourceID, to.URL, shortHash(to.Hash))
+		}
+		from = &page[fromIdx]
+	}
+
+	src, _ := config.GetSourceByID(sourceID)
+	diff, err := DiffUpdates(ctx, storage, src.Normalizer, from, to)
+	if err != nil {
+		return err
+	}
+
+	added, removed := diff.Stats()
+	if to.URL != src.URL {
+		fmt.Fprintf(os.Stdout, "%s\n", to.URL)
+	}
+	fmt.Fprintf(os.Stdout, "--- %s %s (%s)\n", sourceID, shortHash(from.Hash), from.FetchedAt.Format(time.RFC3339))
+	fmt.Fprintf(os.Stdout, "+++ %s %s (%s)\n", sourceID, shortHash(to.Hash), to.FetchedAt.Format(time.RFC3339))
+	if diff.Empty() {
+		fmt.Fprintln(os.Stdout, "No visible text changes.")
+		return nil
+	}
+	fmt.Fprintf(os.Stdout, "%d lines added, %d lines removed\n\n", added, removed)
+	fmt.Fprint(os.Stdout, diff.Format(2))
+
+	return nil
+}
+
+// versionsOf returns the versions of one page, in the order given
+func versionsOf(versions []Update, pageURL string) []Update {
+	var page []Update
+	for _, v := range versions {
+		if v.URL == pageURL {
+			page = append(page, v)
+		}
+	}
+	return page
+}
+
+// resolveVersion finds the version matching a hash prefix or date in a
+// newest-first list of versions
+func resolveVersion(versions []Update, ref string) (int, error) {
+	if date, err := time.Parse("2006-01-02", ref); err == nil {
+		end := date.AddDate(0, 0, 1)
+		for i, v := range versions {
+			if v.FetchedAt.Before(end) {
+				return i, nil
+			}
+		}
+		return 0, fmt.Errorf("no version fetched on or before %s", ref)
+	}
+
+	match := -1
+	for i, v := range versions {
+		if v.Hash != "" && strings.HasPrefix(v.Hash, ref) {
+			if match >= 0 && versions[match].Hash != v.Hash {
+				return 0, fmt.Errorf("hash prefix %q is ambiguous", ref)
+			}
+			if match < 0 {
+				match = i
+			}
+		}
+	}
+	if match < 0 {
+		return 0, fmt.Errorf("no version with hash prefix %q", ref)
+	}
+	return match, nil
+}
+
+// runNotifyCommand requeues notifications given up on after their retries
+// ran out. A running instance delivers them on its next poll, or at startup.
+//
+//	legitrack notify retry [--channel <name>] [event-id...]
+func runNotifyCommand(ctx context.Context, storage Storage, args []string) error {
+	usage := fmt.Errorf("usage: legitrack notify retry [--channel <name>] [event-id...]")
+	if len(args) == 0 || args[0] != "retry" {
+		return usage
+	}
+
+	var channel string
+	var eventIDs []string
+	for rest := args[1:]; len(rest) > 0; rest = rest[1:] {
+		if rest[0] == "--channel" {
+			if len(rest) < 2 {
+				return usage
+			}
+			channel = rest[1]
+			rest = rest[1:]
+			continue
+		}
+		if strings.HasPrefix(rest[0], "-") {
+			return usage
+		}
+		eventIDs = append(eventIDs, rest[0])
+	}
+
+	requeued, err := storage.RetryOutbox(ctx, channel, eventIDs, time.Now())
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stdout, "Requeued %d notifications.\n", requeued)
+	return nil
+}
+
+// runDBCommand inspects or migrates the database schema. It runs before
+// storage is opened, since opening storage applies pending migrations:
+//
+//	legitrack db status
+//	legitrack db migrate [--dry-run]
+func runDBCommand(ctx context.Context, dbPath string, args []string) error {
+	usage := fmt.Errorf("usage: legitrack db status | legitrack db migrate [--dry-run]")
+	if len(args) == 0 {
+		return usage
+	}
+
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+
+	migrator, err := NewMigrator(db)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case args[0] == "status" && len(args) == 1:
+		return printMigrationStatus(ctx, migrator, dbPath)
+	case args[0] == "migrate" && len(args) == 1:
+		applied, err := migrator.Migrate(ctx)
+		for _, m := range applied {
+			fmt.Fprintf(os.Stdout, "Applied %s\n", m)
+		}
+		if err != nil {
+			return err
+		}
+		if len(applied) == 0 {
+			fmt.Fprintln(os.Stdout, "Database is up to date.")
+		}
+		return nil
+	case args[0] == "migrate" && len(args) == 2 && args[1] == "--dry-run":
+		pending, err := migrator.Pending(ctx)
+		if err != nil {
+			return err
+		}
+		if len(pending) == 0 {
+			fmt.Fprintln(os.Stdout, "Database is up to date.")
+			return nil
+		}
+		legacy, err := migrator.Legacy(ctx)
+		if err != nil {
+			return err
+		}
+		for _, m := range pending {
+			if legacy && m.Version == 1 {
+				fmt.Fprintf(os.Stdout, "Would apply %s, upgrading the existing tables in place\n", m)
+				continue
+			}
+			fmt.Fprintf(os.Stdout, "Would apply %s\n", m)
+		}
+		return nil
+	}
+	return usage
+}
+
+// printMigrationStatus lists each migration as applied or pending
+func printMigrationStatus(ctx context.Context, migrator *Migrator, dbPath string) error {
+	status, err := migrator.Status(ctx)
+	if err != nil {
+		return err
+	}
+	legacy, err := migrator.Legacy(ctx)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stdout, "Database: %s\n", dbPath)
+	if legacy {
+		fmt.Fprintln(os.Stdout, "Created before migrations were versioned; the baseline will upgrade it in place.")
+	}
+
+	pending := 0
+	for _, s := range status {
+		switch {
+		case s.Unknown:
+			fmt.Fprintf(os.Stdout, "  %-30s applied %s (not in this build)\n", s.Migration, s.AppliedAt.Format(time.RFC3339))
+		case s.AppliedAt.IsZero():
+			pending++
+			fmt.Fprintf(os.Stdout, "  %-30s pending\n", s.Migration)
+		default:
+			fmt.Fprintf(os.Stdout, "  %-30s applied %s\n", s.Migration, s.AppliedAt.Format(time.RFC3339))
+		}
+	}
+	fmt.Fprintf(os.Stdout, "%d pending\n", pending)
+	return nil
+}
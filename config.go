@@ -1,8 +1,12 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"log"
 	"os"
+	"regexp"
+	"strings"
 	"time"
 
 	"gopkg.in/yaml.v3"
@@ -23,12 +27,39 @@ type GlobalConfig struct {
 	DefaultTimeout    string `yaml:"default_timeout"`
 	DefaultMaxRetries int    `yaml:"default_max_retries"`
 	UserAgent         string `yaml:"user_agent"`
+	RetryBaseDelay    string `yaml:"retry_base_delay"`
+	RetryMaxDelay     string `yaml:"retry_max_delay"`
+	MaxRetryAfter     string `yaml:"max_retry_after"`
+
+	// Per-phase fetch budgets; the source timeout bounds the whole fetch
+	ConnectTimeout        string `yaml:"connect_timeout"`
+	TLSHandshakeTimeout   string `yaml:"tls_handshake_timeout"`
+	ResponseHeaderTimeout string `yaml:"response_header_timeout"`
+	BodyReadTimeout       string `yaml:"body_read_timeout"`
+
+	// How long a fetched robots.txt is trusted before it is fetched again
+	RobotsTTL string `yaml:"robots_ttl"`
+
+	// Scrapes run at once, and the request rate allowed per host
+	MaxConcurrentScrapes int             `yaml:"max_concurrent_scrapes"`
+	RateLimit            RateLimitConfig `yaml:"rate_limit"`
+
+	Renderer RendererConfig `yaml:"renderer"`
+}
+
+// RendererConfig selects the backend used for js_rendered sources
+type RendererConfig struct {
+	Backend          string   `yaml:"backend"` // "chrome" (default) or "command"
+	ChromePath       string   `yaml:"chrome_path"`
+	NetworkIdleQuiet string   `yaml:"network_idle_quiet"`
+	Command          []string `yaml:"command"`
 }
 
 // SourceConfig represents a single source configuration
 type SourceConfig struct {
 	ID          string `yaml:"id"`
 	Name        string `yaml:"name"`
+	Type        string `yaml:"type"` // "page" (default), "feed", "sitemap" or "crawl"
 	URL         string `yaml:"url"`
 	Description string `yaml:"description"`
 	Cron        string `yaml:"cron"`
@@ -36,12 +67,67 @@ type SourceConfig struct {
 	MaxRetries  int    `yaml:"max_retries"`
 	Timeout     string `yaml:"timeout"`
 	Category    string `yaml:"category"`
+
+	// Response policy
+	AcceptedStatus  []int    `yaml:"accepted_status"`
+	MaxRedirects    int      `yaml:"max_redirects"`
+	Soft404Patterns []string `yaml:"soft_404_patterns"`
+
+	// Rendering options for js_rendered sources
+	WaitForSelector string `yaml:"wait_for_selector"`
+	WaitNetworkIdle bool   `yaml:"wait_network_idle"`
+
+	// IgnoreRobots skips robots.txt; only for sites that gave permission to poll
+	IgnoreRobots bool `yaml:"ignore_robots"`
+
+	// Overlap is "skip" (default), "queue" or "cancel" for a run due while
+	// the previous one is still going
+	Overlap string `yaml:"overlap"`
+
+	// Severity is "low", "medium" (default) or "high", for notification routes
+	Severity string `yaml:"severity"`
+
+	Normalize NormalizeConfig `yaml:"normalize"`
+	Extract   ExtractConfig   `yaml:"extract"`
+
+	Attachments AttachmentConfig `yaml:"attachments"`
+	Sitemap     SitemapConfig    `yaml:"sitemap"`
+	Crawl       CrawlConfig      `yaml:"crawl"`
 }
 
 // NotificationConfig contains notification settings
 type NotificationConfig struct {
 	Email   EmailConfig   `yaml:"email"`
 	Webhook WebhookConfig `yaml:"webhook"`
+
+	// Retries of a failed delivery before it is dead-lettered (default 5)
+	MaxRetries int `yaml:"max_retries"`
+
+	// Routes send matching notifications to their own recipients, through
+	// the email settings above, and to the named webhooks they list
+	Webhooks map[string]WebhookConfig `yaml:"webhooks"`
+	Routes   []RouteConfig            `yaml:"routes"`
+
+	// Delivery is "instant" (default), "hourly" or "daily" by recipient
+	// address; digests batch a recipient's changes into one email
+	Delivery map[string]string `yaml:"delivery"`
+
+	// DailyDigestAt is when daily digests go out, as "HH:MM" local time
+	// (default 08:00)
+	DailyDigestAt string `yaml:"daily_digest_at"`
+}
+
+// RouteConfig sends the notifications matching every criterion it sets to
+// its recipients and webhooks
+type RouteConfig struct {
+	Name       string   `yaml:"name"`
+	Sources    []string `yaml:"sources"`    // source IDs
+	Categories []string `yaml:"categories"` // source categories
+	Keywords   []string `yaml:"keywords"`   // any, in the title, summary or changed text
+	Severity   string   `yaml:"severity"`   // minimum source severity
+
+	Recipients []string `yaml:"recipients"`
+	Webhooks   []string `yaml:"webhooks"` // names under notifications.webhooks
 }
 
 // EmailConfig contains email notification settings
@@ -52,6 +138,10 @@ type EmailConfig struct {
 	Username   string   `yaml:"username"`
 	Password   string   `yaml:"password"`
 	Recipients []string `yaml:"recipients"`
+
+	From     string `yaml:"from"`     // defaults to username
+	Security string `yaml:"security"` // "starttls" (default), "tls" (implicit, port 465) or "none"
+	Timeout  string `yaml:"timeout"`  // for the whole SMTP exchange (default 30s)
 }
 
 // WebhookConfig contains webhook notification settings
@@ -59,6 +149,7 @@ type WebhookConfig struct {
 	Enabled bool   `yaml:"enabled"`
 	URL     string `yaml:"url"`
 	Secret  string `yaml:"secret"`
+	Timeout string `yaml:"timeout"` // per delivery attempt (default 10s)
 }
 
 // StorageConfig contains storage settings
@@ -67,6 +158,8 @@ type StorageConfig struct {
 	BackupEnabled    bool   `yaml:"backup_enabled"`
 	BackupInterval   string `yaml:"backup_interval"`
 	MaxRetentionDays int    `yaml:"max_retention_days"`
+	BlobDirectory    string `yaml:"blob_directory"`
+	CompressBodies   bool   `yaml:"compress_bodies"`
 }
 
 // ReportingConfig contains reporting settings
@@ -90,10 +183,23 @@ func LoadConfig(configPath string) (*Config, error) {
 	if err := yaml.Unmarshal(data, &config); err != nil {
 		return nil, fmt.Errorf("failed to parse config file: %w", err)
 	}
+	if err := config.validate(); err != nil {
+		return nil, fmt.Errorf("invalid config file: %w", err)
+	}
 
 	return &config, nil
 }
 
+// validate rejects settings that cannot work, rather than having the
+// channels they configure silently disabled at startup
+func (c *Config) validate() error {
+	email := c.Notifications.Email
+	if email.Enabled && len(email.Recipients) == 0 && len(c.Notifications.Routes) == 0 {
+		return errors.New("notifications.email is enabled but has no recipients or routes")
+	}
+	return nil
+}
+
 // GetSources returns all sources as a slice of Source structs
 func (c *Config) GetSources() []Source {
 	var sources []Source
@@ -115,6 +221,16 @@ func (c *Config) GetSources() []Source {
 	return sources
 }
 
+// GetSourceByID returns the configured source with the given ID
+func (c *Config) GetSourceByID(id string) (Source, bool) {
+	for _, src := range c.GetSources() {
+		if src.ID == id {
+			return src, true
+		}
+	}
+	return Source{}, false
+}
+
 // convertToSource converts a SourceConfig to a Source struct
 func (c *Config) convertToSource(srcConfig SourceConfig) Source {
 	timeout, err := time.ParseDuration(srcConfig.Timeout)
@@ -128,14 +244,136 @@ func (c *Config) convertToSource(srcConfig SourceConfig) Source {
 		maxRetries = c.Global.DefaultMaxRetries
 	}
 
+	sourceType := srcConfig.Type
+	switch sourceType {
+	case "":
+		sourceType = SourceTypePage
+	case SourceTypePage, SourceTypeFeed, SourceTypeSitemap, SourceTypeCrawl:
+	default:
+		log.Printf("[CONFIG] Ignoring unknown type %q for %s; treating it as a page", sourceType, srcConfig.ID)
+		sourceType = SourceTypePage
+	}
+
+	normalizer, err := NewNormalizer(srcConfig.Normalize)
+	if err != nil {
+		log.Printf("[CONFIG] Ignoring invalid normalize rules for %s: %v", srcConfig.ID, err)
+	}
+
+	extractor, err := NewExtractor(srcConfig.Extract)
+	if err != nil {
+		log.Printf("[CONFIG] Ignoring invalid extract spec for %s: %v", srcConfig.ID, err)
+	}
+
+	var attachments nodeSelector
+	if srcConfig.Attachments.Selector != "" {
+		attachments, err = compileSelector(srcConfig.Attachments.Selector)
+		if err != nil {
+			log.Printf("[CONFIG] Ignoring invalid attachments selector for %s: %v", srcConfig.ID, err)
+		}
+	}
+	maxAttachments := srcConfig.Attachments.MaxPerRun
+	if maxAttachments <= 0 {
+		maxAttachments = defaultMaxAttachments
+	}
+	attachmentRecheck := defaultAttachmentRecheck
+	if srcConfig.Attachments.RecheckAfter != "" {
+		d, err := time.ParseDuration(srcConfig.Attachments.RecheckAfter)
+		if err != nil || d <= 0 {
+			log.Printf("[CONFIG] Ignoring invalid attachments recheck_after for %s: %q", srcConfig.ID, srcConfig.Attachments.RecheckAfter)
+		} else {
+			attachmentRecheck = d
+		}
+	}
+
+	maxSitemapFetches := srcConfig.Sitemap.MaxFetchesPerRun
+	if maxSitemapFetches <= 0 {
+		maxSitemapFetches = defaultMaxSitemapFetches
+	}
+
+	crawlDepth := srcConfig.Crawl.MaxDepth
+	if crawlDepth <= 0 {
+		crawlDepth = defaultCrawlDepth
+	}
+	crawlScope := srcConfig.Crawl.Scope
+	switch crawlScope {
+	case "":
+		crawlScope = CrawlScopeHost
+	case CrawlScopeHost, CrawlScopePrefix:
+	default:
+		log.Printf("[CONFIG] Ignoring unknown crawl scope %q for %s; using host", crawlScope, srcConfig.ID)
+		crawlScope = CrawlScopeHost
+	}
+	maxCrawlPages := srcConfig.Crawl.MaxPagesPerRun
+	if maxCrawlPages <= 0 {
+		maxCrawlPages = defaultMaxCrawlPages
+	}
+
+	overlap := srcConfig.Overlap
+	switch overlap {
+	case "":
+		overlap = OverlapSkip
+	case OverlapSkip, OverlapQueue, OverlapCancel:
+	default:
+		log.Printf("[CONFIG] Ignoring unknown overlap policy %q for %s; using skip", overlap, srcConfig.ID)
+		overlap = OverlapSkip
+	}
+
+	severity := strings.ToLower(srcConfig.Severity)
+	if _, ok := severityRank[severity]; !ok {
+		if severity != "" {
+			log.Printf("[CONFIG] Ignoring unknown severity %q for %s; using medium", srcConfig.Severity, srcConfig.ID)
+		}
+		severity = SeverityMedium
+	}
+
 	return Source{
-		ID:         srcConfig.ID,
-		URL:        srcConfig.URL,
-		Cron:       srcConfig.Cron,
-		JSRendered: srcConfig.JSRendered,
-		MaxRetries: maxRetries,
-		Timeout:    timeout,
+		ID:              srcConfig.ID,
+		Name:            srcConfig.Name,
+		Category:        srcConfig.Category,
+		Type:            sourceType,
+		URL:             srcConfig.URL,
+		Cron:            srcConfig.Cron,
+		JSRendered:      srcConfig.JSRendered,
+		MaxRetries:      maxRetries,
+		Timeout:         timeout,
+		AcceptedStatus:  srcConfig.AcceptedStatus,
+		MaxRedirects:    srcConfig.MaxRedirects,
+		Soft404Patterns: compilePatterns(srcConfig.Soft404Patterns, "soft_404", srcConfig.ID),
+		WaitForSelector: srcConfig.WaitForSelector,
+		WaitNetworkIdle: srcConfig.WaitNetworkIdle,
+		IgnoreRobots:    srcConfig.IgnoreRobots,
+		Overlap:         overlap,
+		Severity:        severity,
+		Normalizer:      normalizer,
+		Extractor:       extractor,
+
+		AttachmentSelector: attachments,
+		MaxAttachments:     maxAttachments,
+		AttachmentRecheck:  attachmentRecheck,
+
+		SitemapInclude:    compilePatterns(srcConfig.Sitemap.Include, "sitemap include", srcConfig.ID),
+		MaxSitemapFetches: maxSitemapFetches,
+
+		CrawlMaxDepth: crawlDepth,
+		CrawlScope:    crawlScope,
+		CrawlInclude:  compilePatterns(srcConfig.Crawl.Include, "crawl include", srcConfig.ID),
+		CrawlExclude:  compilePatterns(srcConfig.Crawl.Exclude, "crawl exclude", srcConfig.ID),
+		MaxCrawlPages: maxCrawlPages,
+	}
+}
+
+// compilePatterns compiles a source's regexes, logging and skipping invalid ones
+func compilePatterns(patterns []string, kind, sourceID string) []*regexp.Regexp {
+	var compiled []*regexp.Regexp
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			log.Printf("[CONFIG] Ignoring invalid %s pattern %q for %s: %v", kind, pattern, sourceID, err)
+			continue
+		}
+		compiled = append(compiled, re)
 	}
+	return compiled
 }
 
 // GetDatabasePath returns the database path from config
@@ -146,6 +384,14 @@ func (c *Config) GetDatabasePath() string {
 	return "./legitrack.db" // Default fallback
 }
 
+// GetBlobDirectory returns the directory holding fetched bodies
+func (c *Config) GetBlobDirectory() string {
+	if c.Storage.BlobDirectory != "" {
+		return c.Storage.BlobDirectory
+	}
+	return "./blobs" // Default fallback
+}
+
 // GetUserAgent returns the user agent string
 func (c *Config) GetUserAgent() string {
 	if c.Global.UserAgent != "" {
@@ -154,6 +400,99 @@ func (c *Config) GetUserAgent() string {
 	return "LegiTrack-Bot/1.0 (Legal Compliance Monitor)"
 }
 
+// GetBackoff returns the retry backoff policy, falling back to defaults
+// for any value that is missing or invalid
+func (c *Config) GetBackoff() Backoff {
+	backoff := DefaultBackoff()
+
+	if d, err := time.ParseDuration(c.Global.RetryBaseDelay); err == nil {
+		backoff.Base = d
+	}
+	if d, err := time.ParseDuration(c.Global.RetryMaxDelay); err == nil {
+		backoff.Max = d
+	}
+	if d, err := time.ParseDuration(c.Global.MaxRetryAfter); err == nil {
+		backoff.MaxRetryAfter = d
+	}
+
+	return backoff
+}
+
+// GetHTTPTimeouts returns the per-phase fetch budgets, falling back to
+// defaults for any value that is missing or invalid
+func (c *Config) GetHTTPTimeouts() HTTPTimeouts {
+	timeouts := DefaultHTTPTimeouts()
+
+	if d, err := time.ParseDuration(c.Global.ConnectTimeout); err == nil {
+		timeouts.Connect = d
+	}
+	if d, err := time.ParseDuration(c.Global.TLSHandshakeTimeout); err == nil {
+		timeouts.TLSHandshake = d
+	}
+	if d, err := time.ParseDuration(c.Global.ResponseHeaderTimeout); err == nil {
+		timeouts.ResponseHeader = d
+	}
+	if d, err := time.ParseDuration(c.Global.BodyReadTimeout); err == nil {
+		timeouts.BodyRead = d
+	}
+
+	return timeouts
+}
+
+// GetRobotsTTL returns how long robots.txt files are cached, falling back to
+// the default if missing or invalid
+func (c *Config) GetRobotsTTL() time.Duration {
+	if d, err := time.ParseDuration(c.Global.RobotsTTL); err == nil && d > 0 {
+		return d
+	}
+	return defaultRobotsTTL
+}
+
+// GetMaxConcurrentScrapes returns how many sources may be scraped at once
+func (c *Config) GetMaxConcurrentScrapes() int {
+	if c.Global.MaxConcurrentScrapes > 0 {
+		return c.Global.MaxConcurrentScrapes
+	}
+	return defaultMaxConcurrentScrapes
+}
+
+// GetRateLimit returns the per-host request rates, filling in the default
+// rate and burst where they are unset. A negative rate disables the limit.
+func (c *Config) GetRateLimit() RateLimitConfig {
+	withDefaults := func(r HostRate) HostRate {
+		if r.RequestsPerSecond == 0 {
+			r.RequestsPerSecond = defaultHostRate
+		}
+		if r.Burst <= 0 {
+			r.Burst = defaultHostBurst
+		}
+		return r
+	}
+
+	limits := RateLimitConfig{
+		HostRate: withDefaults(c.Global.RateLimit.HostRate),
+		Hosts:    make(map[string]HostRate),
+	}
+	for host, r := range c.Global.RateLimit.Hosts {
+		limits.Hosts[strings.ToLower(host)] = withDefaults(r)
+	}
+	return limits
+}
+
+// GetDailyDigestCron returns the cron spec for sending daily digests
+func (c *Config) GetDailyDigestCron() string {
+	at := c.Notifications.DailyDigestAt
+	if at == "" {
+		at = defaultDailyDigestAt
+	}
+	t, err := time.Parse("15:04", at)
+	if err != nil {
+		log.Printf("[CONFIG] Invalid daily_digest_at %q, using %s", at, defaultDailyDigestAt)
+		t, _ = time.Parse("15:04", defaultDailyDigestAt)
+	}
+	return fmt.Sprintf("0 %d %d * * *", t.Minute(), t.Hour())
+}
+
 // GetReportingOutputDir returns the reporting output directory
 func (c *Config) GetReportingOutputDir() string {
 	if c.Reporting.OutputDirectory != "" {
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfigEmailRecipients(t *testing.T) {
+	tests := map[string]struct {
+		notifications string
+		wantErr       bool
+	}{
+		"disabled":           {"email: {enabled: false}", false},
+		"default recipients": {"email: {enabled: true, recipients: [legal@example.com]}", false},
+		"route recipients":   {"email: {enabled: true}\n  routes: [{name: kyc, recipients: [kyc@example.com]}]", false},
+		"no recipients":      {"email: {enabled: true, recipients: []}", true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(path, []byte("notifications:\n  "+tt.notifications+"\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := LoadConfig(path)
+			if tt.wantErr && (err == nil || !strings.Contains(err.Error(), "no recipients or routes")) {
+				t.Errorf("LoadConfig error = %v, want email without recipients rejected", err)
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("LoadConfig: %v", err)
+			}
+		})
+	}
+}
+
+func TestLoadConfigShipped(t *testing.T) {
+	if _, err := LoadConfig("config.yaml"); err != nil {
+		t.Fatalf("LoadConfig(config.yaml): %v", err)
+	}
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+const (
+	// SourceTypeCrawl follows links from a source's page to the pages it links to
+	SourceTypeCrawl = "crawl"
+
+	// Crawl scopes
+	CrawlScopeHost   = "host"   // pages on the seed page's host
+	CrawlScopePrefix = "prefix" // pages under the seed page's directory
+
+	// defaultCrawlDepth follows the links of the seed page but not further
+	defaultCrawlDepth = 1
+	// defaultMaxCrawlPages bounds how many pages a crawl run fetches
+	defaultMaxCrawlPages = 20
+)
+
+// CrawlConfig bounds which linked pages a crawl source follows
+type CrawlConfig struct {
+	MaxDepth       int      `yaml:"max_depth"`         // link hops from the source URL (default 1)
+	Scope          string   `yaml:"scope"`             // "host" (default) or "prefix"
+	Include        []string `yaml:"include"`           // regexes a URL must match; empty allows all
+	Exclude        []string `yaml:"exclude"`           // regexes that rule a URL out
+	MaxPagesPerRun int      `yaml:"max_pages_per_run"` // pages fetched per run (default 20)
+}
+
+// CrawlFrontier keeps the pages a crawl has queued and visited, so a crawl
+// interrupted by a crash or by the page budget resumes where it stopped
+type CrawlFrontier interface {
+	// PendingCrawlURLs returns queued pages not yet visited, shallowest first
+	PendingCrawlURLs(ctx context.Context, sourceID string, limit int) ([]CrawlURL, error)
+	// QueueCrawlURLs adds pages, ignoring those already queued or visited in this crawl
+	QueueCrawlURLs(ctx context.Context, sourceID string, urls []CrawlURL) error
+	MarkCrawlURLVisited(ctx context.Context, sourceID, url string, visitedAt time.Time) error
+	// ResetCrawl forgets a finished crawl so the next one starts from the seed
+	ResetCrawl(ctx context.Context, sourceID string) error
+}
+
+// Crawler fetches a source's page and the pages it links to, breadth first,
+// emitting an update per page under the source's ID
+type Crawler struct {
+	http     *HTTPScraper
+	frontier CrawlFrontier
+}
+
+// NewCrawler creates a crawler that fetches through http. Until a frontier is
+// set, crawls are kept in memory and do not survive a restart.
+func NewCrawler(http *HTTPScraper) *Crawler {
+	return &Crawler{http: http, frontier: newMemoryFrontier()}
+}
+
+// SetCrawlFrontier sets where crawl progress is persisted
+func (c *Crawler) SetCrawlFrontier(frontier CrawlFrontier) {
+	c.frontier = frontier
+}
+
+// Scrape continues src's crawl, or starts a new one from src.URL when the
+// previous crawl finished, fetching at most src.MaxCrawlPages pages
+func (c *Crawler) Scrape(ctx context.Context, src Source, out chan<- Update) error {
+	seed, err := url.Parse(src.URL)
+	if err != nil {
+		return fmt.Errorf("invalid crawl URL: %w", err)
+	}
+
+	pending, err := c.frontier.PendingCrawlURLs(ctx, src.ID, src.MaxCrawlPages)
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		log.Printf("[CRAWL] Resuming crawl of %s", src.URL)
+	} else {
+		log.Printf("[CRAWL] Starting crawl of %s", src.URL)
+		if err := c.frontier.ResetCrawl(ctx, src.ID); err != nil {
+			return err
+		}
+		seedURL := CrawlURL{URL: src.URL, QueuedAt: time.Now().UTC()}
+		if err := c.frontier.QueueCrawlURLs(ctx, src.ID, []CrawlURL{seedURL}); err != nil {
+			return err
+		}
+	}
+
+	fetched, discovered := 0, 0
+	for fetched < src.MaxCrawlPages && ctx.Err() == nil {
+		batch, err := c.frontier.PendingCrawlURLs(ctx, src.ID, src.MaxCrawlPages-fetched)
+		if err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			break
+		}
+
+		for _, entry := range batch {
+			if ctx.Err() != nil {
+				break
+			}
+			fetched++
+
+			links, err := c.visit(ctx, src, entry, out)
+			if err != nil {
+				log.Printf("[CRAWL] Failed to fetch %s: %v", entry.URL, err)
+			}
+
+			if entry.Depth < src.CrawlMaxDepth {
+				var queue []CrawlURL
+				for _, link := range links {
+					if inCrawlScope(src, seed, link) {
+						queue = append(queue, CrawlURL{URL: link, Depth: entry.Depth + 1, QueuedAt: time.Now().UTC()})
+					}
+				}
+				if err := c.frontier.QueueCrawlURLs(ctx, src.ID, queue); err != nil {
+					return err
+				}
+				discovered += len(queue)
+			}
+
+			if err := c.frontier.MarkCrawlURLVisited(ctx, src.ID, entry.URL, time.Now().UTC()); err != nil {
+				return err
+			}
+		}
+	}
+
+	remaining, err := c.frontier.PendingCrawlURLs(ctx, src.ID, 1)
+	if err != nil {
+		return err
+	}
+	if len(remaining) > 0 {
+		log.Printf("[CRAWL] Fetched %d pages of %s (%d links in scope); continuing next run",
+			fetched, src.ID, discovered)
+	} else {
+		log.Printf("[CRAWL] Finished crawl of %s (%d pages this run, %d links in scope)",
+			src.ID, fetched, discovered)
+	}
+	return nil
+}
+
+// visit fetches a single page of a crawl, emits its update and returns the
+// links it contains. A failed seed page is reported as a failed update; a
+// failed linked page is only logged, as broken links are common.
+func (c *Crawler) visit(ctx context.Context, src Source, entry CrawlURL, out chan<- Update) ([]string, error) {
+	page := src
+	page.URL = entry.URL
+
+	var resp *fetchResult
+	attempts, err := retry(ctx, src.ID, src.MaxRetries, c.http.backoff, func(attempt int) error {
+		r, err := c.http.fetch(ctx, page, entry.URL, nil)
+		if err != nil {
+			return err
+		}
+		resp = r
+		return nil
+	})
+	if err != nil {
+		if entry.Depth == 0 {
+			out <- failedUpdate(page, attempts, err)
+		}
+		return nil, err
+	}
+
+	// Validators belong to individual pages, not to the source's state
+	update := c.http.pageUpdate(ctx, page, resp, attempts)
+	update.ETag = ""
+	update.LastModified = ""
+	out <- update
+
+	return crawlLinks(entry.URL, resp.Body), nil
+}
+
+// crawlLinks returns the absolute URLs a page links to, in document order
+// without duplicates. Bodies that are not HTML have no links.
+func crawlLinks(pageURL string, body []byte) []string {
+	if !isHTML(body) {
+		return nil
+	}
+
+	doc, err := html.Parse(bytes.NewReader(body))
+	if err != nil {
+		return nil
+	}
+
+	base, _ := url.Parse(pageURL)
+	var links []string
+	seen := make(map[string]bool)
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && (n.DataAtom == atom.A || n.DataAtom == atom.Area) {
+			if link, ok := resolveLink(base, attrValue(n, "href")); ok && !seen[link] {
+				seen[link] = true
+				links = append(links, link)
+			}
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(doc)
+	return links
+}
+
+// inCrawlScope reports whether a link may be followed by src's crawl: it must
+// be on the seed's host (and under its directory for the prefix scope), match
+// an include pattern if any are set, and match no exclude pattern
+func inCrawlScope(src Source, seed *url.URL, link string) bool {
+	u, err := url.Parse(link)
+	if err != nil || !strings.EqualFold(u.Host, seed.Host) {
+		return false
+	}
+
+	if src.CrawlScope == CrawlScopePrefix {
+		dir := seed.Path[:strings.LastIndex(seed.Path, "/")+1]
+		if !strings.HasPrefix(u.Path, dir) {
+			return false
+		}
+	}
+
+	if len(src.CrawlInclude) > 0 && !matchesAny(src.CrawlInclude, link) {
+		return false
+	}
+	return !matchesAny(src.CrawlExclude, link)
+}
+
+// matchesAny reports whether s matches one of the patterns
+func matchesAny(patterns []*regexp.Regexp, s string) bool {
+	for _, re := range patterns {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// memoryFrontier is a CrawlFrontier that lives only as long as the process
+type memoryFrontier struct {
+	mu     sync.Mutex
+	crawls map[string][]CrawlURL
+}
+
+func newMemoryFrontier() *memoryFrontier {
+	return &memoryFrontier{crawls: make(map[string][]CrawlURL)}
+}
+
+func (m *memoryFrontier) PendingCrawlURLs(ctx context.Context, sourceID string, limit int) ([]CrawlURL, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Pages are queued in breadth-first order, so queue order is shallowest first
+	var pending []CrawlURL
+	for _, u := range m.crawls[sourceID] {
+		if len(pending) >= limit {
+			break
+		}
+		if u.VisitedAt.IsZero() {
+			pending = append(pending, u)
+		}
+	}
+	return pending, nil
+}
+
+func (m *memoryFrontier) QueueCrawlURLs(ctx context.Context, sourceID string, urls []CrawlURL) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	known := make(map[string]bool)
+	for _, u := range m.crawls[sourceID] {
+		known[u.URL] = true
+	}
+	for _, u := range urls {
+		if !known[u.URL] {
+			known[u.URL] = true
+			m.crawls[sourceID] = append(m.crawls[sourceID], u)
+		}
+	}
+	return nil
+}
+
+func (m *memoryFrontier) MarkCrawlURLVisited(ctx context.Context, sourceID, url string, visitedAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.crawls[sourceID] {
+		if m.crawls[sourceID][i].URL == url {
+			m.crawls[sourceID][i].VisitedAt = visitedAt
+		}
+	}
+	return nil
+}
+
+func (m *memoryFrontier) ResetCrawl(ctx context.Context, sourceID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.crawls, sourceID)
+	return nil
+}
@@ -0,0 +1,477 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// maxEditDistance bounds diff work on pages that were rewritten wholesale
+const maxEditDistance = 4000
+
+// DiffOp identifies the kind of a diff edit
+type DiffOp int
+
+const (
+	DiffEqual DiffOp = iota
+	DiffDelete
+	DiffInsert
+)
+
+// DiffEdit is a single line or word of an edit script
+type DiffEdit struct {
+	Op   DiffOp
+	Text string
+}
+
+// IsInsert reports whether the edit adds text
+func (e DiffEdit) IsInsert() bool { return e.Op == DiffInsert }
+
+// IsDelete reports whether the edit removes text
+func (e DiffEdit) IsDelete() bool { return e.Op == DiffDelete }
+
+// ChangeBlock is a run of consecutive removed and added lines. When a block
+// both removes and adds lines, Words holds a word-level diff between them.
+type ChangeBlock struct {
+	Removed []string
+	Added   []string
+	Words   []DiffEdit
+}
+
+// TextDiff is a line-level diff between two versions of a page's text
+type TextDiff struct {
+	Edits  []DiffEdit
+	Blocks []ChangeBlock
+}
+
+// DiffText compares two texts line by line, with word-level detail for
+// lines that were modified rather than added or removed outright
+func DiffText(oldText, newText string) *TextDiff {
+	edits := diffStrings(splitLines(oldText), splitLines(newText))
+
+	d := &TextDiff{Edits: edits}
+	var block *ChangeBlock
+	for _, e := range edits {
+		if e.Op == DiffEqual {
+			if block != nil {
+				d.Blocks = append(d.Blocks, finishBlock(*block))
+				block = nil
+			}
+			continue
+		}
+		if block == nil {
+			block = &ChangeBlock{}
+		}
+		if e.Op == DiffDelete {
+			block.Removed = append(block.Removed, e.Text)
+		} else {
+			block.Added = append(block.Added, e.Text)
+		}
+	}
+	if block != nil {
+		d.Blocks = append(d.Blocks, finishBlock(*block))
+	}
+
+	return d
+}
+
+// finishBlock adds the word-level diff to a block that modifies lines
+func finishBlock(b ChangeBlock) ChangeBlock {
+	if len(b.Removed) > 0 && len(b.Added) > 0 {
+		b.Words = diffStrings(
+			strings.Fields(strings.Join(b.Removed, " ")),
+			strings.Fields(strings.Join(b.Added, " ")),
+		)
+	}
+	return b
+}
+
+// splitLines splits text into lines, treating empty text as no lines
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(text, "\n")
+}
+
+// Empty reports whether the two texts were identical
+func (d *TextDiff) Empty() bool {
+	return len(d.Blocks) == 0
+}
+
+// Stats returns the number of lines added and removed
+func (d *TextDiff) Stats() (added, removed int) {
+	for _, b := range d.Blocks {
+		added += len(b.Added)
+		removed += len(b.Removed)
+	}
+	return added, removed
+}
+
+// Format renders the diff as unified-style hunks with the given lines of context
+func (d *TextDiff) Format(context int) string {
+	var b strings.Builder
+
+	// Mark which equal lines fall within context of a change
+	show := make([]bool, len(d.Edits))
+	for i, e := range d.Edits {
+		if e.Op == DiffEqual {
+			continue
+		}
+		for j := i - context; j <= i+context; j++ {
+			if j >= 0 && j < len(d.Edits) {
+				show[j] = true
+			}
+		}
+	}
+
+	gap := false
+	for i, e := range d.Edits {
+		if !show[i] {
+			gap = true
+			continue
+		}
+		if gap && b.Len() > 0 {
+			b.WriteString("...\n")
+		}
+		gap = false
+
+		switch e.Op {
+		case DiffEqual:
+			b.WriteString("  ")
+		case DiffDelete:
+			b.WriteString("- ")
+		case DiffInsert:
+			b.WriteString("+ ")
+		}
+		b.WriteString(e.Text)
+		b.WriteByte('\n')
+	}
+
+	return b.String()
+}
+
+// FormatWords renders a word-level diff in git's [-removed-]{+added+} style
+func FormatWords(words []DiffEdit) string {
+	var parts []string
+	for _, w := range words {
+		switch w.Op {
+		case DiffEqual:
+			parts = append(parts, w.Text)
+		case DiffDelete:
+			parts = append(parts, "[-"+w.Text+"-]")
+		case DiffInsert:
+			parts = append(parts, "{+"+w.Text+"+}")
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// ChangeExcerpt is a compact "what changed" summary of a diff
+type ChangeExcerpt struct {
+	Added     int
+	Removed   int
+	Blocks    []ChangeBlock
+	Truncated bool
+}
+
+// Excerpt returns the first maxBlocks change blocks of the diff
+func (d *TextDiff) Excerpt(maxBlocks int) *ChangeExcerpt {
+	excerpt := &ChangeExcerpt{Blocks: d.Blocks}
+	excerpt.Added, excerpt.Removed = d.Stats()
+	if len(excerpt.Blocks) > maxBlocks {
+		excerpt.Blocks = excerpt.Blocks[:maxBlocks]
+		excerpt.Truncated = true
+	}
+	return excerpt
+}
+
+// maxExcerptBlocks limits how many change blocks reports and notifications
+// show per update
+const maxExcerptBlocks = 3
+
+// changeExcerpt shows the first changes since the previous version of an
+// update's page, or nil where changeDiff has no diff
+func changeExcerpt(ctx context.Context, storage Storage, normalizer *Normalizer, update Update) (*ChangeExcerpt, error) {
+	diff, err := changeDiff(ctx, storage, normalizer, update)
+	if diff == nil || err != nil {
+		return nil, err
+	}
+	return diff.Excerpt(maxExcerptBlocks), nil
+}
+
+// changeDiff diffs an update against the previous version of its page.
+// It returns nil for failures, first versions, and versions whose bodies
+// were not retained.
+func changeDiff(ctx context.Context, storage Storage, normalizer *Normalizer, update Update) (*TextDiff, error) {
+	if !update.Success || update.BodyHash == "" {
+		return nil, nil
+	}
+
+	previous, err := storage.GetPreviousUpdate(ctx, update.SourceID, update.URL, update.FetchedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find previous version of %s: %w", update.SourceID, err)
+	}
+	if previous == nil || previous.BodyHash == "" {
+		return nil, nil
+	}
+
+	diff, err := DiffUpdates(ctx, storage, normalizer, previous, &update)
+	if err != nil {
+		return nil, fmt.Errorf("failed to diff %s: %w", update.SourceID, err)
+	}
+
+	return diff, nil
+}
+
+// DiffUpdates compares the visible text of two stored versions of a source.
+// The source's normalizer, if any, is applied first so diffs show the same
+// content that change detection hashes.
+func DiffUpdates(ctx context.Context, storage Storage, normalizer *Normalizer, from, to *Update) (*TextDiff, error) {
+	oldText, err := versionText(ctx, storage, normalizer, from)
+	if err != nil {
+		return nil, err
+	}
+	newText, err := versionText(ctx, storage, normalizer, to)
+	if err != nil {
+		return nil, err
+	}
+
+	return DiffText(oldText, newText), nil
+}
+
+// versionText loads a stored version's body and reduces it to visible text.
+// Documents use the text extracted when they were fetched. A version that
+// still holds its content, such as one about to be stored, is used as is.
+func versionText(ctx context.Context, storage Storage, normalizer *Normalizer, version *Update) (string, error) {
+	label := "version " + shortHash(version.Hash)
+	if version.Text != "" {
+		return bodyText(normalizer, []byte(version.Text), label), nil
+	}
+	if len(version.Body) > 0 {
+		return bodyText(normalizer, version.Body, label), nil
+	}
+
+	if version.TextHash != "" {
+		text, err := storage.GetBody(ctx, version.TextHash)
+		if err != nil {
+			return "", err
+		}
+		if text != nil {
+			return bodyText(normalizer, text, "version "+shortHash(version.Hash)), nil
+		}
+	}
+
+	body, err := storage.GetBody(ctx, version.BodyHash)
+	if err != nil {
+		return "", err
+	}
+	if body == nil {
+		return "", fmt.Errorf("body of version %s is not stored", shortHash(version.Hash))
+	}
+
+	return bodyText(normalizer, body, "version "+shortHash(version.Hash)), nil
+}
+
+// bodyText reduces a body to visible text after applying normalizer, if any.
+// label identifies the body in log messages.
+func bodyText(normalizer *Normalizer, body []byte, label string) string {
+	if normalizer == nil {
+		return visibleText(body)
+	}
+
+	normalized, err := normalizer.Normalize(body)
+	if err != nil {
+		log.Printf("[DIFF] Normalization failed for %s: %v; using raw body", label, err)
+		return visibleText(body)
+	}
+
+	// A selected fragment may not look like a document any more
+	if isHTML(body) {
+		return htmlVisibleText(normalized)
+	}
+	return normalizeLines(string(normalized))
+}
+
+// shortHash abbreviates a content hash for display
+func shortHash(hash string) string {
+	if len(hash) > 12 {
+		return hash[:12]
+	}
+	return hash
+}
+
+// diffStrings computes a minimal edit script turning a into b using Myers'
+// O(ND) algorithm. Inputs that differ too much are reported as a wholesale
+// replacement.
+func diffStrings(a, b []string) []DiffEdit {
+	// Common prefixes and suffixes are cheap to peel off and usually dominate
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix &&
+		a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	var edits []DiffEdit
+	for _, s := range a[:prefix] {
+		edits = append(edits, DiffEdit{Op: DiffEqual, Text: s})
+	}
+	edits = append(edits, myers(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for _, s := range a[len(a)-suffix:] {
+		edits = append(edits, DiffEdit{Op: DiffEqual, Text: s})
+	}
+	return edits
+}
+
+// myers computes a minimal edit script with the linear-space refinement of
+// Myers' algorithm: it finds the middle snake of an optimal path, then
+// recurses on the parts before and after it, so only two frontiers are kept
+// however far apart the inputs are. Inputs further apart than
+// maxEditDistance are reported as a wholesale replacement.
+func myers(a, b []string) []DiffEdit {
+	n, m := len(a), len(b)
+	if n == 0 && m == 0 {
+		return nil
+	}
+
+	bound := min((n+m+1)/2, (maxEditDistance+1)/2) + 1
+	md := &myersDiff{
+		a:   a,
+		b:   b,
+		fwd: make([]int, 2*bound+3),
+		bwd: make([]int, 2*bound+3),
+		off: bound + 1,
+	}
+
+	// The top-level search alone tells whether the inputs are close enough
+	if n > 0 && m > 0 {
+		if _, _, _, _, ok := md.middleSnake(0, n, 0, m, maxEditDistance); !ok {
+			edits := make([]DiffEdit, 0, n+m)
+			for _, s := range a {
+				edits = append(edits, DiffEdit{Op: DiffDelete, Text: s})
+			}
+			for _, s := range b {
+				edits = append(edits, DiffEdit{Op: DiffInsert, Text: s})
+			}
+			return edits
+		}
+	}
+
+	md.compare(0, n, 0, m)
+	return md.edits
+}
+
+// myersDiff holds the inputs, frontiers and output of a linear-space diff.
+// fwd and bwd hold the furthest x reached on each diagonal k, indexed by
+// k+off, searching from the start and from the end respectively.
+type myersDiff struct {
+	a, b     []string
+	fwd, bwd []int
+	off      int
+	edits    []DiffEdit
+}
+
+// compare appends the edit script turning a[a0:a1] into b[b0:b1]
+func (md *myersDiff) compare(a0, a1, b0, b1 int) {
+	for a0 < a1 && b0 < b1 && md.a[a0] == md.b[b0] {
+		md.edits = append(md.edits, DiffEdit{Op: DiffEqual, Text: md.a[a0]})
+		a0++
+		b0++
+	}
+	suffix := 0
+	for a0 < a1-suffix && b0 < b1-suffix && md.a[a1-1-suffix] == md.b[b1-1-suffix] {
+		suffix++
+	}
+	a1 -= suffix
+	b1 -= suffix
+
+	switch {
+	case a0 == a1:
+		for _, s := range md.b[b0:b1] {
+			md.edits = append(md.edits, DiffEdit{Op: DiffInsert, Text: s})
+		}
+	case b0 == b1:
+		for _, s := range md.a[a0:a1] {
+			md.edits = append(md.edits, DiffEdit{Op: DiffDelete, Text: s})
+		}
+	default:
+		// With the ends stripped the distance is at least two, and each
+		// side of the middle snake is strictly closer
+		x, y, u, v, _ := md.middleSnake(a0, a1, b0, b1, a1-a0+b1-b0)
+		md.compare(a0, a0+x, b0, b0+y)
+		for _, s := range md.a[a0+x : a0+u] {
+			md.edits = append(md.edits, DiffEdit{Op: DiffEqual, Text: s})
+		}
+		md.compare(a0+u, a1, b0+v, b1)
+	}
+
+	for _, s := range md.a[a1 : a1+suffix] {
+		md.edits = append(md.edits, DiffEdit{Op: DiffEqual, Text: s})
+	}
+}
+
+// middleSnake finds the middle snake of an optimal path from a[a0:a1] to
+// b[b0:b1], running the search forward from the start and backward from the
+// end until they overlap. The snake runs from (x, y) to (u, v), relative to
+// a0 and b0. ok is false if the distance exceeds limit.
+func (md *myersDiff) middleSnake(a0, a1, b0, b1, limit int) (x, y, u, v int, ok bool) {
+	n, m := a1-a0, b1-b0
+	delta := n - m
+	odd := delta%2 != 0
+	fwd, bwd, off := md.fwd, md.bwd, md.off
+	fwd[off+1] = 0
+	bwd[off+1] = 0
+
+	for d := 0; d <= (n+m+1)/2; d++ {
+		if 2*d-1 > limit {
+			return 0, 0, 0, 0, false
+		}
+
+		for k := -d; k <= d; k += 2 {
+			var px int
+			if k == -d || (k != d && fwd[off+k-1] < fwd[off+k+1]) {
+				px = fwd[off+k+1]
+			} else {
+				px = fwd[off+k-1] + 1
+			}
+			py := px - k
+			sx, sy := px, py
+			for px < n && py < m && md.a[a0+px] == md.b[b0+py] {
+				px++
+				py++
+			}
+			fwd[off+k] = px
+
+			if rk := delta - k; odd && rk >= -(d-1) && rk <= d-1 && px+bwd[off+rk] >= n {
+				return sx, sy, px, py, true
+			}
+		}
+
+		for k := -d; k <= d; k += 2 {
+			var px int
+			if k == -d || (k != d && bwd[off+k-1] < bwd[off+k+1]) {
+				px = bwd[off+k+1]
+			} else {
+				px = bwd[off+k-1] + 1
+			}
+			py := px - k
+			sx, sy := px, py
+			for px < n && py < m && md.a[a1-1-px] == md.b[b1-1-py] {
+				px++
+				py++
+			}
+			bwd[off+k] = px
+
+			if fk := delta - k; !odd && fk >= -d && fk <= d && px+fwd[off+fk] >= n {
+				return n - px, m - py, n - sx, m - sy, true
+			}
+		}
+	}
+
+	return 0, 0, 0, 0, false
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// applyEdits rebuilds both sides of an edit script: the text it removes
+// from and the text it produces
+func applyEdits(edits []DiffEdit) (from, to []string) {
+	for _, e := range edits {
+		if e.Op != DiffInsert {
+			from = append(from, e.Text)
+		}
+		if e.Op != DiffDelete {
+			to = append(to, e.Text)
+		}
+	}
+	return from, to
+}
+
+// editCount counts the lines an edit script inserts or deletes
+func editCount(edits []DiffEdit) int {
+	n := 0
+	for _, e := range edits {
+		if e.Op != DiffEqual {
+			n++
+		}
+	}
+	return n
+}
+
+// editDistance is the fewest insertions and deletions turning a into b,
+// from the longest common subsequence
+func editDistance(a, b []string) int {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	return len(a) + len(b) - 2*lcs[0][0]
+}
+
+// lines splits a string into one line per character, to write inputs compactly
+func lines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "")
+}
+
+func TestDiffStrings(t *testing.T) {
+	tests := map[string]struct {
+		a, b     string
+		distance int
+	}{
+		"both empty":       {"", "", 0},
+		"from empty":       {"", "abc", 3},
+		"to empty":         {"abc", "", 3},
+		"identical":        {"abc", "abc", 0},
+		"insert in middle": {"ac", "abc", 1},
+		"delete in middle": {"abc", "ac", 1},
+		"replace one":      {"abc", "axc", 2},
+		"no common lines":  {"abc", "xyz", 6},
+		"myers paper":      {"abcabba", "cbabac", 5},
+		"moved block":      {"abcdef", "defabc", 6},
+		"repeated lines":   {"aaaabaaaa", "aaaacaaaa", 2},
+		"odd delta":        {"abcbdab", "bdcaba", 5},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			a, b := lines(tt.a), lines(tt.b)
+			edits := diffStrings(a, b)
+
+			from, to := applyEdits(edits)
+			if strings.Join(from, "") != tt.a || strings.Join(to, "") != tt.b {
+				t.Fatalf("edit script turns %q into %q, want %q into %q",
+					strings.Join(from, ""), strings.Join(to, ""), tt.a, tt.b)
+			}
+			if got := editCount(edits); got != tt.distance {
+				t.Errorf("edit script has %d edits, want %d", got, tt.distance)
+			}
+		})
+	}
+}
+
+func TestDiffStringsRandom(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	random := func() []string {
+		s := make([]string, rng.Intn(15))
+		for i := range s {
+			s[i] = string(rune('a' + rng.Intn(3)))
+		}
+		return s
+	}
+
+	for i := 0; i < 2000; i++ {
+		a, b := random(), random()
+		edits := diffStrings(a, b)
+
+		from, to := applyEdits(edits)
+		if strings.Join(from, "") != strings.Join(a, "") || strings.Join(to, "") != strings.Join(b, "") {
+			t.Fatalf("edit script for %q to %q does not round trip", a, b)
+		}
+		if got, want := editCount(edits), editDistance(a, b); got != want {
+			t.Fatalf("%q to %q: %d edits, want a minimal %d", a, b, got, want)
+		}
+	}
+}
+
+func TestMiddleSnake(t *testing.T) {
+	tests := map[string]struct{ a, b string }{
+		"myers paper":  {"abcabba", "cbabac"},
+		"odd delta":    {"abcbdab", "bdcaba"},
+		"even delta":   {"xaxbxc", "aybycy"},
+		"one inserted": {"ab", "axb"},
+		"one deleted":  {"axb", "ab"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			a, b := lines(tt.a), lines(tt.b)
+			n, m := len(a), len(b)
+			md := &myersDiff{a: a, b: b, fwd: make([]int, 2*(n+m)+3), bwd: make([]int, 2*(n+m)+3), off: n + m + 1}
+
+			distance := editDistance(a, b)
+			x, y, u, v, ok := md.middleSnake(0, n, 0, m, n+m)
+			if !ok {
+				t.Fatal("no middle snake found within the inputs' length")
+			}
+			if u-x != v-y || x > u || u > n || v > m {
+				t.Fatalf("snake (%d,%d)-(%d,%d) is not a diagonal within the inputs", x, y, u, v)
+			}
+			for i := x; i < u; i++ {
+				if a[i] != b[y+i-x] {
+					t.Fatalf("snake (%d,%d)-(%d,%d) crosses unequal lines", x, y, u, v)
+				}
+			}
+			if got := editDistance(a[:x], b[:y]) + editDistance(a[u:], b[v:]); got != distance {
+				t.Errorf("snake (%d,%d)-(%d,%d) lies on a path of %d edits, want an optimal %d", x, y, u, v, got, distance)
+			}
+
+			if _, _, _, _, ok := md.middleSnake(0, n, 0, m, distance-2); ok {
+				t.Errorf("found a middle snake within a limit of %d, below the distance %d", distance-2, distance)
+			}
+		})
+	}
+}
+
+func TestDiffStringsMaxEditDistance(t *testing.T) {
+	// Pairs of a changed line and a kept one; each pair adds two edits
+	pairs := func(n int) (a, b []string) {
+		for i := 0; i < n; i++ {
+			a = append(a, fmt.Sprintf("old %d", i), fmt.Sprintf("kept %d", i))
+			b = append(b, fmt.Sprintf("new %d", i), fmt.Sprintf("kept %d", i))
+		}
+		return a, b
+	}
+
+	tests := map[string]struct {
+		pairs     int
+		wholesale bool
+	}{
+		"at the limit":     {maxEditDistance / 2, false},
+		"beyond the limit": {maxEditDistance/2 + 5, true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			a, b := pairs(tt.pairs)
+			edits := diffStrings(a, b)
+
+			from, to := applyEdits(edits)
+			if strings.Join(from, "\n") != strings.Join(a, "\n") || strings.Join(to, "\n") != strings.Join(b, "\n") {
+				t.Fatal("edit script does not round trip")
+			}
+
+			// Only the last kept line is peeled off as a common suffix
+			// before a wholesale replacement
+			kept := len(edits) - editCount(edits)
+			if tt.wholesale && kept != 1 {
+				t.Errorf("kept %d lines, want a wholesale replacement", kept)
+			}
+			if !tt.wholesale && kept != tt.pairs {
+				t.Errorf("kept %d lines, want all %d unchanged lines", kept, tt.pairs)
+			}
+		})
+	}
+}
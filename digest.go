@@ -0,0 +1,415 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	htmltemplate "html/template"
+	"log"
+	"net/mail"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Delivery modes of a recipient
+const (
+	DeliveryInstant = "instant" // an email for each change
+	DeliveryHourly  = "hourly"  // a digest of the last hour's changes
+	DeliveryDaily   = "daily"   // a digest of the last day's changes
+)
+
+// defaultDailyDigestAt is when daily digests go out
+const defaultDailyDigestAt = "08:00"
+
+// digestPeriods is how far back a recipient's first digest in each mode goes
+var digestPeriods = map[string]time.Duration{
+	DeliveryHourly: time.Hour,
+	DeliveryDaily:  24 * time.Hour,
+}
+
+// recipientKey identifies a recipient by address, whatever the display name
+// or case it is configured with
+func recipientKey(recipient string) string {
+	if addr, err := mail.ParseAddress(recipient); err == nil {
+		return strings.ToLower(addr.Address)
+	}
+	return strings.ToLower(strings.TrimSpace(recipient))
+}
+
+// deliveryMode returns how a recipient's notifications are delivered.
+// Recipients not listed, or listed with an unknown mode, get them instantly.
+func deliveryMode(cfg NotificationConfig, recipient string) string {
+	key := recipientKey(recipient)
+	for address, mode := range cfg.Delivery {
+		if recipientKey(address) != key {
+			continue
+		}
+		mode = strings.ToLower(mode)
+		if _, ok := digestPeriods[mode]; ok {
+			return mode
+		}
+	}
+	return DeliveryInstant
+}
+
+// deliveryRecipients returns the recipients delivered to in a mode
+func deliveryRecipients(cfg NotificationConfig, recipients []string, mode string) []string {
+	var selected []string
+	for _, recipient := range recipients {
+		if deliveryMode(cfg, recipient) == mode {
+			selected = append(selected, recipient)
+		}
+	}
+	return selected
+}
+
+// digestRecipient is a recipient of digests and the changes they get
+type digestRecipient struct {
+	address string
+	all     bool     // a default recipient, sent every change
+	routes  []*Route // otherwise the changes matching any of these
+}
+
+// wants reports whether a change belongs in the recipient's digest
+func (r *digestRecipient) wants(n Notification) bool {
+	if r.all {
+		return true
+	}
+	for _, route := range r.routes {
+		if route.Matches(n) {
+			return true
+		}
+	}
+	return false
+}
+
+// Digester mails recipients who asked for hourly or daily delivery one
+// message batching the changes since their last digest. Each recipient's
+// progress is saved, so a digest that fails to send is caught up by the next.
+type Digester struct {
+	storage    Storage
+	sources    map[string]Source
+	email      *EmailNotifier
+	recipients map[string][]*digestRecipient // by delivery mode
+}
+
+// NewDigester creates a digester for the default and routed recipients
+// configured for digests, mailed through the configured SMTP server
+func NewDigester(storage Storage, sources map[string]Source, cfg NotificationConfig, routes []configuredRoute) *Digester {
+	d := &Digester{
+		storage:    storage,
+		sources:    sources,
+		recipients: make(map[string][]*digestRecipient),
+	}
+
+	for address, mode := range cfg.Delivery {
+		switch strings.ToLower(mode) {
+		case DeliveryInstant, DeliveryHourly, DeliveryDaily:
+		default:
+			log.Printf("[NOTIFY] Unknown delivery %q for %s, delivering instantly", mode, address)
+		}
+	}
+	if !cfg.Email.Enabled {
+		return d
+	}
+
+	byKey := make(map[string]*digestRecipient)
+	var addresses []string
+	add := func(address string) *digestRecipient {
+		mode := deliveryMode(cfg, address)
+		if mode == DeliveryInstant {
+			return nil
+		}
+		key := mode + " " + recipientKey(address)
+		r, ok := byKey[key]
+		if !ok {
+			r = &digestRecipient{address: address}
+			byKey[key] = r
+			d.recipients[mode] = append(d.recipients[mode], r)
+			addresses = append(addresses, address)
+		}
+		return r
+	}
+
+	for _, address := range cfg.Email.Recipients {
+		if r := add(address); r != nil {
+			r.all = true
+		}
+	}
+	for _, route := range routes {
+		for _, address := range route.Recipients {
+			if r := add(address); r != nil {
+				r.routes = append(r.routes, route.Route)
+			}
+		}
+	}
+	if len(addresses) == 0 {
+		return d
+	}
+
+	emailCfg := cfg.Email
+	emailCfg.Recipients = addresses
+	email, err := NewEmailNotifier(emailCfg)
+	if err != nil {
+		log.Printf("[NOTIFY] Email digests disabled: %v", err)
+		d.recipients = make(map[string][]*digestRecipient)
+		return d
+	}
+	d.email = email
+	return d
+}
+
+// Enabled reports whether anyone gets digests in a mode
+func (d *Digester) Enabled(mode string) bool {
+	return len(d.recipients[mode]) > 0
+}
+
+// Send mails each recipient in a mode the changes saved since their last
+// digest. Recipients with nothing new get no message.
+func (d *Digester) Send(ctx context.Context, mode string, now time.Time) {
+	now = now.UTC().Truncate(time.Second)
+
+	// Updates are tracked by the order they were saved in, not when they
+	// were fetched, so a version saved late goes in the next digest
+	through, err := d.storage.GetLatestUpdateID(ctx)
+	if err != nil {
+		log.Printf("[NOTIFY] %v", err)
+		return
+	}
+
+	// Recipients usually share a window, so each change is diffed once
+	notifications := make(map[string]Notification)
+	notification := func(update Update) Notification {
+		key := update.SourceID + "." + update.URL + "." + update.FetchedAt.String()
+		n, ok := notifications[key]
+		if !ok {
+			src, found := d.sources[update.SourceID]
+			if !found {
+				src = Source{ID: update.SourceID}
+			}
+			n = newNotification(ctx, d.storage, src, update)
+			notifications[key] = n
+		}
+		return n
+	}
+
+	for _, r := range d.recipients[mode] {
+		if ctx.Err() != nil {
+			return
+		}
+
+		key := recipientKey(r.address)
+		cursor, err := d.storage.GetDigestCursor(ctx, key, mode)
+		if err != nil {
+			log.Printf("[NOTIFY] %v", err)
+			continue
+		}
+		if cursor == nil {
+			// A first digest covers the mode's period
+			since := now.Add(-digestPeriods[mode])
+			lastID, err := d.storage.GetUpdateIDAt(ctx, since)
+			if err != nil {
+				log.Printf("[NOTIFY] %v", err)
+				continue
+			}
+			cursor = &DigestCursor{Recipient: key, Mode: mode, LastUpdateID: lastID, CoveredAt: since}
+		}
+		if cursor.LastUpdateID >= through {
+			continue
+		}
+
+		updates, err := d.storage.GetUpdatesAfterID(ctx, cursor.LastUpdateID, through)
+		if err != nil {
+			log.Printf("[NOTIFY] %v", err)
+			continue
+		}
+
+		var changes []Notification
+		for _, update := range updates {
+			if !update.Success {
+				continue
+			}
+			if n := notification(update); r.wants(n) {
+				changes = append(changes, n)
+			}
+		}
+
+		next := *cursor
+		next.LastUpdateID = through
+		next.CoveredAt = now
+
+		if len(changes) == 0 {
+			if err := d.storage.SaveDigestCursor(ctx, next); err != nil {
+				log.Printf("[NOTIFY] %v", err)
+			}
+			continue
+		}
+
+		digest := newDigest(mode, cursor.CoveredAt, now, changes)
+		digest.AfterID, digest.ThroughID = cursor.LastUpdateID, through
+		msg, err := buildDigestEmail(d.email.from, r.address, digest, now)
+		if err == nil {
+			err = d.email.send(ctx, []string{r.address}, msg)
+		}
+		if err != nil {
+			// The cursor stays put, so the next digest includes these changes
+			log.Printf("[NOTIFY] Failed to send %s digest to %s: %v", mode, r.address, err)
+			continue
+		}
+
+		next.LastSentAt = now
+		if err := d.storage.SaveDigestCursor(ctx, next); err != nil {
+			log.Printf("[NOTIFY] %v", err)
+		}
+		log.Printf("[NOTIFY] Sent %s digest of %d changes to %s", mode, len(changes), r.address)
+	}
+}
+
+// Digest is the content of a digest email
+type Digest struct {
+	Mode    string
+	Since   time.Time
+	Until   time.Time
+	Changes int
+	Sources int
+	Groups  []DigestCategory
+
+	// The updates covered: saved after AfterID, up to ThroughID
+	AfterID   int64
+	ThroughID int64
+}
+
+// DigestCategory holds the changes to the sources of one category
+type DigestCategory struct {
+	Category string
+	Sources  []DigestSource
+}
+
+// DigestSource holds the changes to one source, oldest first
+type DigestSource struct {
+	ID      string
+	Name    string
+	Changes []Notification
+}
+
+// newDigest groups changes by category, then source, both by name
+func newDigest(mode string, since, until time.Time, changes []Notification) Digest {
+	digest := Digest{Mode: mode, Since: since, Until: until, Changes: len(changes)}
+
+	categories := make(map[string]map[string]*DigestSource)
+	for _, n := range changes {
+		category := n.Category
+		if category == "" {
+			category = "uncategorized"
+		}
+		if categories[category] == nil {
+			categories[category] = make(map[string]*DigestSource)
+		}
+		src := categories[category][n.SourceID]
+		if src == nil {
+			src = &DigestSource{ID: n.SourceID, Name: n.SourceName}
+			categories[category][n.SourceID] = src
+			digest.Sources++
+		}
+		src.Changes = append(src.Changes, n)
+	}
+
+	for category, sources := range categories {
+		group := DigestCategory{Category: category}
+		for _, src := range sources {
+			group.Sources = append(group.Sources, *src)
+		}
+		sort.Slice(group.Sources, func(i, j int) bool {
+			return group.Sources[i].Name < group.Sources[j].Name
+		})
+		digest.Groups = append(digest.Groups, group)
+	}
+	sort.Slice(digest.Groups, func(i, j int) bool {
+		return digest.Groups[i].Category < digest.Groups[j].Category
+	})
+
+	return digest
+}
+
+// Title names the digest, e.g. "Daily digest"
+func (d Digest) Title() string {
+	return strings.ToUpper(d.Mode[:1]) + d.Mode[1:] + " digest"
+}
+
+// Counts says how much changed, e.g. "3 changes from 2 sources"
+func (d Digest) Counts() string {
+	changes, sources := "changes", "sources"
+	if d.Changes == 1 {
+		changes = "change"
+	}
+	if d.Sources == 1 {
+		sources = "source"
+	}
+	return fmt.Sprintf("%d %s from %d %s", d.Changes, changes, d.Sources, sources)
+}
+
+// Subject is the subject line of a digest email
+func (d Digest) Subject() string {
+	return "[LegiTrack] " + d.Title() + ": " + d.Counts()
+}
+
+// digestText is the plain-text part of a digest email
+var digestText = template.Must(template.New("digest-text").Parse(`LegiTrack {{.Title}}
+{{.Counts}}, {{.Since.Format "2006-01-02 15:04"}} to {{.Until.Format "2006-01-02 15:04 MST"}}
+{{range .Groups}}
+== {{.Category}} ==
+{{range .Sources}}
+{{.Name}} ({{.ID}})
+{{range .Changes}}
+  * {{if .Title}}{{.Title}}{{else}}{{.Headline}}{{end}}
+    {{.URL}}
+    Detected {{.FetchedAt.Format "2006-01-02 15:04 MST"}}{{if .Reverted}}, reverted to an earlier version{{end}}
+{{- with .Change}}, +{{.Added}} / -{{.Removed}} lines{{end}}
+{{- if .Summary}}
+    {{.Summary}}{{end}}
+{{end}}{{end}}{{end}}
+Run "legitrack diff <source-id>" for the full changes.
+`))
+
+// digestHTML is the HTML part of a digest email
+var digestHTML = htmltemplate.Must(htmltemplate.New("digest-html").Parse(`<!DOCTYPE html>
+<html>
+<body style="font-family: sans-serif; line-height: 1.5; color: #333;">
+<h2 style="color: #667eea;">LegiTrack {{.Title}}</h2>
+<p style="color: #666;">{{.Counts}}, {{.Since.Format "2006-01-02 15:04"}} to {{.Until.Format "2006-01-02 15:04 MST"}}</p>
+{{range .Groups}}
+<h3 style="border-bottom: 1px solid #ddd;">{{.Category}}</h3>
+{{range .Sources}}
+<h4 style="margin-bottom: 4px;">{{.Name}} <span style="color: #666; font-weight: normal;">({{.ID}})</span></h4>
+<ul style="margin-top: 0;">
+{{range .Changes}}<li style="margin-bottom: 8px;">
+<a href="{{.URL}}">{{if .Title}}{{.Title}}{{else}}{{.Headline}}{{end}}</a>
+<div style="color: #666;">Detected {{.FetchedAt.Format "2006-01-02 15:04 MST"}}{{if .Reverted}}, reverted to an earlier version{{end}}{{with .Change}}, <span style="color: #28a745;">+{{.Added}}</span> / <span style="color: #dc3545;">-{{.Removed}}</span> lines{{end}}</div>
+{{if .Summary}}<div>{{.Summary}}</div>{{end}}
+</li>{{end}}
+</ul>
+{{end}}{{end}}
+<p style="color: #666;">Run <code>legitrack diff &lt;source-id&gt;</code> for the full changes.</p>
+</body>
+</html>
+`))
+
+// buildDigestEmail renders a digest as a multipart/alternative message to
+// one recipient
+func buildDigestEmail(from, to string, d Digest, now time.Time) ([]byte, error) {
+	var text, html bytes.Buffer
+	if err := digestText.Execute(&text, d); err != nil {
+		return nil, fmt.Errorf("failed to render digest text: %w", err)
+	}
+	if err := digestHTML.Execute(&html, d); err != nil {
+		return nil, fmt.Errorf("failed to render digest HTML: %w", err)
+	}
+
+	// The same for a digest resent after its cursor failed to save, as it
+	// covers the same updates
+	messageID := fmt.Sprintf("<digest.%s.%d-%d.%s@legitrack>",
+		d.Mode, d.AfterID, d.ThroughID, shortHash(sha256Hex([]byte(recipientKey(to)))))
+	return composeEmail(from, []string{to}, d.Subject(), messageID, text.Bytes(), html.Bytes(), now)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestStorage opens a storage in a temporary directory
+func newTestStorage(t *testing.T) *SQLiteStorage {
+	t.Helper()
+	dir := t.TempDir()
+	blobs, err := NewFileBlobStore(filepath.Join(dir, "blobs"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage, err := NewSQLiteStorage(filepath.Join(dir, "legitrack.db"), blobs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { storage.Close() })
+	return storage
+}
+
+// saveTestUpdate stores a successful fetch of a circular page
+func saveTestUpdate(t *testing.T, storage Storage, title string, fetchedAt time.Time) {
+	t.Helper()
+	body := []byte("<html><head><title>" + title + "</title></head><body>" + title + "</body></html>")
+	update := Update{
+		SourceID:    "rbi",
+		URL:         "https://rbi.example/circulars",
+		FetchedAt:   fetchedAt,
+		Hash:        sha256Hex(body),
+		BodyHash:    sha256Hex(body),
+		Body:        body,
+		StatusCode:  200,
+		Success:     true,
+		Title:       title,
+		ContentType: "text/html",
+	}
+	if err := storage.SaveUpdate(context.Background(), update); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDigestIncludesLateSavedUpdates(t *testing.T) {
+	server := newSMTPStandIn(t, nil)
+	storage := newTestStorage(t)
+	emailCfg := server.config(EmailSecurityNone)
+	emailCfg.Recipients = []string{"compliance@example.com"}
+	cfg := NotificationConfig{
+		Email:    emailCfg,
+		Delivery: map[string]string{"compliance@example.com": DeliveryHourly},
+	}
+	sources := map[string]Source{"rbi": {ID: "rbi", Name: "RBI Circulars", URL: "https://rbi.example/circulars"}}
+	digester := NewDigester(storage, sources, cfg, nil)
+
+	start := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+	saveTestUpdate(t, storage, "Circular on KYC norms", start.Add(-30*time.Minute))
+	digester.Send(context.Background(), DeliveryHourly, start)
+
+	// Fetched before the first digest went out, but saved after it
+	saveTestUpdate(t, storage, "Circular on priority lending", start.Add(-20*time.Minute))
+	digester.Send(context.Background(), DeliveryHourly, start.Add(time.Hour))
+
+	// Nothing new was saved, so no third digest
+	digester.Send(context.Background(), DeliveryHourly, start.Add(2*time.Hour))
+
+	messages := server.received()
+	if len(messages) != 2 {
+		t.Fatalf("received %d digests, want 2", len(messages))
+	}
+	for i, want := range []string{"Circular on KYC norms", "Circular on priority lending"} {
+		if data := string(messages[i].data); !strings.Contains(data, want) {
+			t.Errorf("digest %d does not mention %q", i+1, want)
+		}
+	}
+	if strings.Contains(string(messages[1].data), "Circular on KYC norms") {
+		t.Error("second digest repeats a change from the first")
+	}
+}
+
+func TestDigestMessageIDStableAcrossResends(t *testing.T) {
+	messageID := func(until time.Time) string {
+		t.Helper()
+		d := newDigest(DeliveryDaily, until.Add(-24*time.Hour), until, []Notification{testNotification()})
+		d.AfterID, d.ThroughID = 41, 57
+		msg, err := buildDigestEmail("alerts@legitrack.example", "Compliance <compliance@example.com>", d, until)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parseEmail(t, msg).header.Get("Message-ID")
+	}
+
+	first := time.Date(2026, 3, 2, 8, 0, 0, 0, time.UTC)
+	sent, resent := messageID(first), messageID(first.Add(24*time.Hour))
+	if sent == "" || sent != resent {
+		t.Errorf("Message-ID = %q, then %q on resend; want the same for the same updates", sent, resent)
+	}
+}
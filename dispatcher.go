@@ -0,0 +1,397 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	// defaultMaxConcurrentScrapes bounds how many sources are scraped at once
+	defaultMaxConcurrentScrapes = 4
+	// Overlap policies, for a run due while the previous one is still going
+	OverlapSkip   = "skip"   // drop the new run
+	OverlapQueue  = "queue"  // run it once the previous one finishes
+	OverlapCancel = "cancel" // cancel the previous run and start the new one
+
+	// defaultHostRate and defaultHostBurst pace requests to a single host
+	defaultHostRate  = 1.0
+	defaultHostBurst = 2
+)
+
+// HostRate is a token bucket: a sustained request rate and the burst allowed above it
+type HostRate struct {
+	RequestsPerSecond float64 `yaml:"requests_per_second"`
+	Burst             int     `yaml:"burst"`
+}
+
+// RateLimitConfig sets the request rate for every host, with overrides by host name
+type RateLimitConfig struct {
+	HostRate `yaml:",inline"`
+	Hosts    map[string]HostRate `yaml:"hosts"` // e.g. "www.sebi.gov.in"
+}
+
+// HostLimiter paces requests with one token bucket per host, so sources
+// that share a host share its budget
+type HostLimiter struct {
+	config RateLimitConfig
+
+	mu       sync.Mutex
+	limiters map[string]*rate.Limiter
+
+	throttled atomic.Int64
+	waited    atomic.Int64 // nanoseconds
+}
+
+// NewHostLimiter creates a limiter from config. A host whose rate is zero or
+// negative is not limited.
+func NewHostLimiter(config RateLimitConfig) *HostLimiter {
+	return &HostLimiter{config: config, limiters: make(map[string]*rate.Limiter)}
+}
+
+// limiter returns the token bucket of a host, creating it on first use
+func (l *HostLimiter) limiter(host string) *rate.Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if lim, ok := l.limiters[host]; ok {
+		return lim
+	}
+
+	hostRate := l.config.HostRate
+	if override, ok := l.config.Hosts[host]; ok {
+		hostRate = override
+	}
+
+	lim := rate.NewLimiter(rate.Inf, 0)
+	if hostRate.RequestsPerSecond > 0 {
+		burst := hostRate.Burst
+		if burst < 1 {
+			burst = 1
+		}
+		lim = rate.NewLimiter(rate.Limit(hostRate.RequestsPerSecond), burst)
+	}
+	l.limiters[host] = lim
+	return lim
+}
+
+// Wait blocks until a request to target's host is allowed or ctx ends
+func (l *HostLimiter) Wait(ctx context.Context, target string) error {
+	if l == nil {
+		return nil
+	}
+	u, err := url.Parse(target)
+	if err != nil || u.Host == "" {
+		return nil
+	}
+
+	start := time.Now()
+	if err := l.limiter(strings.ToLower(u.Hostname())).Wait(ctx); err != nil {
+		return err
+	}
+	if waited := time.Since(start); waited > time.Millisecond {
+		l.throttled.Add(1)
+		l.waited.Add(int64(waited))
+	}
+	return nil
+}
+
+// DispatcherStats are the queueing metrics of a Dispatcher
+type DispatcherStats struct {
+	Queued    int64 // jobs waiting for a worker
+	Running   int64
+	Completed int64
+	Failed    int64
+	Dropped   int64 // jobs refused because the queue was full
+	Skipped   int64 // runs not started, by overlap policy or a full queue
+	Cancelled int64 // runs cancelled in favor of a newer run
+
+	AvgQueueWait time.Duration
+	MaxQueueWait time.Duration
+
+	// Requests delayed by the per-host rate limit, and for how long in total
+	Throttled    int64
+	ThrottleWait time.Duration
+}
+
+// scrapeJob is a scrape waiting for a worker
+type scrapeJob struct {
+	src      Source
+	reason   string // "initial" or "scheduled"
+	queuedAt time.Time
+}
+
+// Dispatcher runs scrapes on a fixed pool of workers, so a burst of cron
+// ticks queues up instead of hitting every site at once
+type Dispatcher struct {
+	scraper Scraper
+	limiter *HostLimiter
+	workers int
+	jobs    chan scrapeJob
+	wg      sync.WaitGroup
+
+	// closed guards jobs against a cron tick arriving during Stop, and
+	// tells workers to skip what is still queued
+	mu     sync.RWMutex
+	closed atomic.Bool
+
+	// runs holds each source with a run queued or in progress
+	runsMu sync.Mutex
+	runs   map[string]*sourceRun
+	skips  SkipRecorder
+
+	queued, running, completed, failed, dropped atomic.Int64
+	skipped, cancelled                          atomic.Int64
+	totalWait, maxWait                          atomic.Int64 // nanoseconds
+}
+
+// sourceRun is a source's active run, from submission until it finishes
+type sourceRun struct {
+	running bool
+	cancel  context.CancelFunc
+	next    *scrapeJob // held back until the current run finishes
+}
+
+// SkipRecorder records runs that were skipped, so schedule health is visible
+type SkipRecorder interface {
+	RecordSkippedRun(ctx context.Context, sourceID string, at time.Time) error
+}
+
+// NewDispatcher creates a dispatcher running at most workers scrapes at once
+// and holding up to queueSize waiting jobs. The limiter is only used for
+// reporting; scrapers wait on it themselves.
+func NewDispatcher(scraper Scraper, limiter *HostLimiter, workers, queueSize int) *Dispatcher {
+	if workers <= 0 {
+		workers = defaultMaxConcurrentScrapes
+	}
+	if queueSize < workers {
+		queueSize = workers
+	}
+	return &Dispatcher{
+		scraper: scraper,
+		limiter: limiter,
+		workers: workers,
+		jobs:    make(chan scrapeJob, queueSize),
+		runs:    make(map[string]*sourceRun),
+	}
+}
+
+// SetSkipRecorder sets where skipped runs are recorded
+func (d *Dispatcher) SetSkipRecorder(recorder SkipRecorder) {
+	d.skips = recorder
+}
+
+// Start launches the workers, which send updates to out until ctx ends or
+// Stop is called
+func (d *Dispatcher) Start(ctx context.Context, out chan<- Update) {
+	for i := 0; i < d.workers; i++ {
+		d.wg.Add(1)
+		go func() {
+			defer d.wg.Done()
+			for job := range d.jobs {
+				d.run(ctx, job, out)
+			}
+		}()
+	}
+}
+
+// Submit queues a scrape of src without blocking. When src already has a
+// run queued or in progress, its overlap policy decides whether the new run
+// is skipped, held until the current one finishes, or replaces it. Submit
+// reports whether the run was accepted; refused runs are logged and recorded.
+func (d *Dispatcher) Submit(src Source, reason string) bool {
+	if d.closed.Load() {
+		return false
+	}
+	job := scrapeJob{src: src, reason: reason, queuedAt: time.Now()}
+
+	d.runsMu.Lock()
+	if run, active := d.runs[src.ID]; active {
+		why := d.overlap(run, job)
+		d.runsMu.Unlock()
+		if why != "" {
+			d.skip(job, why)
+			return false
+		}
+		return true
+	}
+	d.runs[src.ID] = &sourceRun{}
+	d.runsMu.Unlock()
+
+	if !d.enqueue(job) {
+		d.finish(src.ID)
+		if !d.closed.Load() {
+			d.dropped.Add(1)
+			d.skip(job, "queue full")
+		}
+		return false
+	}
+	return true
+}
+
+// overlap applies src's overlap policy to a job submitted while run is
+// active. It returns why the job is skipped, or "" if it was held as the
+// source's next run. Called with runsMu held.
+func (d *Dispatcher) overlap(run *sourceRun, job scrapeJob) string {
+	switch {
+	case job.src.Overlap == OverlapSkip:
+		return "previous run still in progress"
+	case !run.running || run.next != nil:
+		// The waiting run will pick up any change this one would have seen
+		return "a run is already waiting"
+	case job.src.Overlap == OverlapCancel:
+		log.Printf("[DISPATCH] Cancelling running scrape of %s for a new %s run", job.src.ID, job.reason)
+		run.cancel()
+		d.cancelled.Add(1)
+	}
+	run.next = &job
+	return ""
+}
+
+// skip logs and records a run that will not happen
+func (d *Dispatcher) skip(job scrapeJob, why string) {
+	d.skipped.Add(1)
+	log.Printf("[DISPATCH] Skipping %s scrape of %s: %s", job.reason, job.src.ID, why)
+
+	if d.skips != nil {
+		if err := d.skips.RecordSkippedRun(context.Background(), job.src.ID, time.Now().UTC()); err != nil {
+			log.Printf("[DISPATCH] Failed to record skipped run of %s: %v", job.src.ID, err)
+		}
+	}
+}
+
+// enqueue queues a job without blocking, reporting false if the queue is
+// full or the dispatcher stopped
+func (d *Dispatcher) enqueue(job scrapeJob) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.closed.Load() {
+		return false
+	}
+
+	d.queued.Add(1)
+	select {
+	case d.jobs <- job:
+		return true
+	default:
+		d.queued.Add(-1)
+		return false
+	}
+}
+
+// finish ends a source's active run, queueing the run held back behind it
+func (d *Dispatcher) finish(sourceID string) {
+	d.runsMu.Lock()
+	run, ok := d.runs[sourceID]
+	if !ok || run.next == nil {
+		delete(d.runs, sourceID)
+		d.runsMu.Unlock()
+		return
+	}
+	next := *run.next
+	d.runs[sourceID] = &sourceRun{}
+	d.runsMu.Unlock()
+
+	if !d.enqueue(next) {
+		d.finish(sourceID)
+		if !d.closed.Load() {
+			d.dropped.Add(1)
+			d.skip(next, "queue full")
+		}
+	}
+}
+
+// run scrapes a single job, recording how long it waited
+func (d *Dispatcher) run(ctx context.Context, job scrapeJob, out chan<- Update) {
+	d.queued.Add(-1)
+	defer d.finish(job.src.ID)
+	if ctx.Err() != nil || d.closed.Load() {
+		return
+	}
+
+	wait := time.Since(job.queuedAt)
+	d.totalWait.Add(int64(wait))
+	for {
+		max := d.maxWait.Load()
+		if int64(wait) <= max || d.maxWait.CompareAndSwap(max, int64(wait)) {
+			break
+		}
+	}
+
+	// The overlap policy may cancel this run in favor of a newer one
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	d.runsMu.Lock()
+	if run, ok := d.runs[job.src.ID]; ok {
+		run.running = true
+		run.cancel = cancel
+	}
+	d.runsMu.Unlock()
+
+	d.running.Add(1)
+	defer d.running.Add(-1)
+
+	log.Printf("[DISPATCH] Starting %s scrape of %s (waited %s, %d queued)",
+		job.reason, job.src.ID, wait.Round(time.Millisecond), d.queued.Load())
+	if err := d.scraper.Scrape(ctx, job.src, out); err != nil {
+		if errors.Is(err, context.Canceled) {
+			log.Printf("[DISPATCH] %s scrape of %s cancelled", job.reason, job.src.ID)
+			return
+		}
+		d.failed.Add(1)
+		log.Printf("[DISPATCH] %s scrape of %s failed: %v", job.reason, job.src.ID, err)
+		return
+	}
+	d.completed.Add(1)
+}
+
+// Stop stops accepting jobs, drops the queued ones and waits for the running
+// ones to finish
+func (d *Dispatcher) Stop() {
+	d.mu.Lock()
+	if !d.closed.Load() {
+		d.closed.Store(true)
+		close(d.jobs)
+	}
+	d.mu.Unlock()
+	d.wg.Wait()
+}
+
+// Stats returns a snapshot of the dispatcher's queueing metrics
+func (d *Dispatcher) Stats() DispatcherStats {
+	stats := DispatcherStats{
+		Queued:       d.queued.Load(),
+		Running:      d.running.Load(),
+		Completed:    d.completed.Load(),
+		Failed:       d.failed.Load(),
+		Dropped:      d.dropped.Load(),
+		Skipped:      d.skipped.Load(),
+		Cancelled:    d.cancelled.Load(),
+		MaxQueueWait: time.Duration(d.maxWait.Load()),
+	}
+	if started := stats.Running + stats.Completed + stats.Failed; started > 0 {
+		stats.AvgQueueWait = time.Duration(d.totalWait.Load() / started)
+	}
+	if d.limiter != nil {
+		stats.Throttled = d.limiter.throttled.Load()
+		stats.ThrottleWait = time.Duration(d.limiter.waited.Load())
+	}
+	return stats
+}
+
+// LogStats logs the dispatcher's queueing metrics
+func (d *Dispatcher) LogStats() {
+	s := d.Stats()
+	log.Printf("[DISPATCH] queued: %d, running: %d, completed: %d, failed: %d, dropped: %d, "+
+		"skipped: %d, cancelled: %d, avg wait: %s, max wait: %s, throttled requests: %d (%s)",
+		s.Queued, s.Running, s.Completed, s.Failed, s.Dropped, s.Skipped, s.Cancelled,
+		s.AvgQueueWait.Round(time.Millisecond), s.MaxQueueWait.Round(time.Millisecond),
+		s.Throttled, s.ThrottleWait.Round(time.Millisecond))
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"mime"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ledongthuc/pdf"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+const (
+	// defaultMaxAttachments bounds how many attachments are fetched per run
+	defaultMaxAttachments = 10
+
+	// defaultAttachmentRecheck is how often a known attachment is re-fetched
+	// to catch a file replaced under the same URL
+	defaultAttachmentRecheck = 24 * time.Hour
+)
+
+// AttachmentConfig selects document links to follow from a source's page
+type AttachmentConfig struct {
+	Selector     string `yaml:"selector"`      // e.g. "a[href$='.pdf']"
+	MaxPerRun    int    `yaml:"max_per_run"`   // attachments fetched per run
+	RecheckAfter string `yaml:"recheck_after"` // e.g. "24h"
+}
+
+// DocumentIndex reports what is known of the attachments of a source
+type DocumentIndex interface {
+	GetDocumentState(ctx context.Context, sourceID, url string) (*DocumentState, error)
+}
+
+// AttachmentFetcher downloads the documents linked from a fetched page
+type AttachmentFetcher interface {
+	FetchAttachments(ctx context.Context, src Source, body []byte) []Document
+}
+
+// PDFText is the text of a PDF document, one entry per page
+type PDFText struct {
+	Pages []string
+	Title string // from the document information dictionary, if set
+}
+
+// Text returns the text of all pages, one line of text per line
+func (t *PDFText) Text() string {
+	var pages []string
+	for _, page := range t.Pages {
+		if page != "" {
+			pages = append(pages, page)
+		}
+	}
+	return strings.Join(pages, "\n")
+}
+
+// isPDF reports whether a response is a PDF, by its declared Content-Type or
+// by its magic bytes, since servers often label PDFs application/octet-stream
+func isPDF(contentType string, body []byte) bool {
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil && mediaType == "application/pdf" {
+		return true
+	}
+	return bytes.HasPrefix(body, []byte("%PDF-"))
+}
+
+// extractPDFText extracts the text of each page of a PDF. Pages that cannot
+// be decoded are left empty rather than failing the whole document. The PDF
+// library panics on some malformed files, such as one whose xref points at
+// garbage; those are reported as errors.
+func extractPDFText(body []byte) (text *PDFText, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			text, err = nil, fmt.Errorf("malformed PDF: %v", r)
+		}
+	}()
+
+	reader, err := pdf.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open PDF: %w", err)
+	}
+
+	result := &PDFText{
+		Title: strings.TrimSpace(reader.Trailer().Key("Info").Key("Title").Text()),
+	}
+
+	var failed int
+	for i := 1; i <= reader.NumPage(); i++ {
+		page := reader.Page(i)
+		if page.V.IsNull() {
+			result.Pages = append(result.Pages, "")
+			continue
+		}
+
+		// Font resource names are per page, so fonts are not shared between pages
+		text, err := page.GetPlainText(nil)
+		if err != nil {
+			failed++
+			text = ""
+		}
+		result.Pages = append(result.Pages, normalizeLines(text))
+	}
+
+	if failed > 0 && failed == len(result.Pages) {
+		return nil, fmt.Errorf("failed to extract text from any of %d pages", failed)
+	}
+
+	return result, nil
+}
+
+// sha256Hex returns the hex SHA-256 of data, the key of its stored blob
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// newDocument builds the Document for a fetched attachment, extracting its
+// text when it is a PDF
+func newDocument(docURL, contentType string, body []byte) Document {
+	doc := Document{
+		URL:         docURL,
+		ContentType: contentType,
+		Body:        body,
+		BodyHash:    sha256Hex(body),
+	}
+
+	if !isPDF(contentType, body) {
+		return doc
+	}
+
+	text, err := extractPDFText(body)
+	if err != nil {
+		log.Printf("[DOCUMENT] %s: %v", docURL, err)
+		return doc
+	}
+	doc.Title = text.Title
+	doc.Pages = len(text.Pages)
+	doc.Text = text.Text()
+	doc.TextHash = sha256Hex([]byte(doc.Text))
+	return doc
+}
+
+// attachmentLinks returns the absolute URLs of the links in a page matched
+// by the source's attachment selector, in document order without duplicates
+func attachmentLinks(src Source, body []byte) []string {
+	if src.AttachmentSelector == nil || !isHTML(body) {
+		return nil
+	}
+
+	doc, err := html.Parse(bytes.NewReader(body))
+	if err != nil {
+		return nil
+	}
+
+	base, _ := url.Parse(src.URL)
+	var links []string
+	seen := make(map[string]bool)
+	for _, node := range src.AttachmentSelector.Select(doc) {
+		href := attrValue(node, "href")
+		if href == "" && node.DataAtom != atom.A {
+			// An XPath attribute match carries its value as text
+			href = nodeText(node)
+		}
+		link, ok := resolveLink(base, href)
+		if ok && !seen[link] {
+			seen[link] = true
+			links = append(links, link)
+		}
+	}
+	return links
+}
+
+// resolveLink resolves href against the page at base, returning the absolute
+// http(s) URL without its fragment. Links back to the page itself, such as
+// in-page anchors, are rejected.
+func resolveLink(base *url.URL, href string) (string, bool) {
+	if href = strings.TrimSpace(href); href == "" {
+		return "", false
+	}
+
+	ref, err := url.Parse(href)
+	if err != nil {
+		return "", false
+	}
+	if base != nil {
+		ref = base.ResolveReference(ref)
+	}
+	if ref.Scheme != "http" && ref.Scheme != "https" {
+		return "", false
+	}
+	ref.Fragment = ""
+
+	link := ref.String()
+	if base != nil && link == pageURL(base) {
+		return "", false
+	}
+	return link, true
+}
+
+// pageURL returns u without its fragment
+func pageURL(u *url.URL) string {
+	stripped := *u
+	stripped.Fragment = ""
+	return stripped.String()
+}
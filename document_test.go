@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// pageContent draws one line of text on the test PDF's page
+const pageContent = "BT /F1 24 Tf 72 720 Td (Circular on KYC norms) Tj ET"
+
+// buildPDF assembles a one-page PDF with a title. redirect maps an object
+// number to a byte offset the xref table should point at instead of the
+// object, to build files whose xref points at garbage.
+func buildPDF(redirect map[int]int) []byte {
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] /Contents 4 0 R /Resources << /Font << /F1 5 0 R >> >> >>",
+		fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(pageContent), pageContent),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>",
+		"<< /Title (RBI Master Circular) >>",
+	}
+
+	var b strings.Builder
+	b.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, object := range objects {
+		offsets[i] = b.Len()
+		fmt.Fprintf(&b, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+
+	xref := b.Len()
+	fmt.Fprintf(&b, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for i, offset := range offsets {
+		if to, ok := redirect[i+1]; ok {
+			offset = to
+		}
+		fmt.Fprintf(&b, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&b, "trailer\n<< /Size %d /Root 1 0 R /Info 6 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return []byte(b.String())
+}
+
+func TestExtractPDFText(t *testing.T) {
+	text, err := extractPDFText(buildPDF(nil))
+	if err != nil {
+		t.Fatalf("extractPDFText: %v", err)
+	}
+	if text.Title != "RBI Master Circular" {
+		t.Errorf("Title = %q, want %q", text.Title, "RBI Master Circular")
+	}
+	if got := text.Text(); got != "Circular on KYC norms" {
+		t.Errorf("Text = %q, want %q", got, "Circular on KYC norms")
+	}
+}
+
+func TestExtractPDFTextMalformed(t *testing.T) {
+	valid := buildPDF(nil)
+
+	// Offset 3 lands inside the "%PDF-1.4" header, which the PDF library
+	// panics on when it resolves the object
+	tests := map[string][]byte{
+		"truncated":          valid[:len(valid)/2],
+		"truncated with EOF": append(append([]byte{}, valid[:len(valid)/2]...), "\n%%EOF\n"...),
+		"garbage info":       buildPDF(map[int]int{6: 3}),
+		"garbage pages":      buildPDF(map[int]int{2: 3}),
+		"garbage page":       buildPDF(map[int]int{3: 3}),
+		"header only":        []byte("%PDF-1.7\n"),
+	}
+	for name, body := range tests {
+		t.Run(name, func(t *testing.T) {
+			text, err := extractPDFText(body)
+			if err == nil {
+				t.Fatalf("extractPDFText succeeded with %q", text.Text())
+			}
+		})
+	}
+}
+
+func TestDescribeBodyMalformedPDF(t *testing.T) {
+	body := buildPDF(map[int]int{3: 3})
+	update := Update{ContentType: "application/pdf", Body: body}
+	describeBody(Source{ID: "rbi"}, &update)
+
+	if update.Text != "" || update.TextHash != "" || update.Title != "" {
+		t.Errorf("update has text %q, text hash %q, title %q; want a document without text",
+			update.Text, update.TextHash, update.Title)
+	}
+	if want := sha256Hex(body); update.Hash != want || update.BodyHash != want {
+		t.Errorf("hashes = %s, %s; want the raw file's %s", update.Hash, update.BodyHash, want)
+	}
+}
+
+func TestNewDocumentMalformedPDF(t *testing.T) {
+	body := buildPDF(map[int]int{6: 3})
+	doc := newDocument("https://rbi.example/circular.pdf", "application/pdf", body)
+
+	if doc.Text != "" || doc.Pages != 0 {
+		t.Errorf("document has %d pages, text %q; want none", doc.Pages, doc.Text)
+	}
+	if doc.BodyHash != sha256Hex(body) {
+		t.Error("document body was not hashed")
+	}
+}
+
+func TestFetchAttachmentsRechecksReplacedFiles(t *testing.T) {
+	var mu sync.Mutex
+	version, etag := "Circular version 1", `"v1"`
+	var conditional []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		conditional = append(conditional, r.Header.Get("If-None-Match"))
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprint(w, version)
+	}))
+	defer server.Close()
+
+	selector, err := compileSelector("a[href$='.pdf']")
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := Source{
+		ID:                 "rbi",
+		URL:                server.URL + "/circulars",
+		IgnoreRobots:       true,
+		AttachmentSelector: selector,
+		MaxAttachments:     10,
+	}
+	page := []byte(`<html><body><a href="/kyc.pdf">KYC norms</a></body></html>`)
+
+	storage := newTestStorage(t)
+	h := NewHTTPScraper()
+	h.SetDocumentIndex(storage)
+	h.SetBackoff(Backoff{Base: time.Millisecond, Max: time.Millisecond})
+
+	start := time.Now().UTC().Add(-time.Hour).Truncate(time.Second)
+	run := func(at time.Time) ([]Document, []Document) {
+		t.Helper()
+		docs := h.FetchAttachments(context.Background(), src, page)
+		saved, err := storage.SaveDocuments(context.Background(), Update{SourceID: src.ID, URL: src.URL, FetchedAt: at, Documents: docs})
+		if err != nil {
+			t.Fatalf("SaveDocuments: %v", err)
+		}
+		return docs, saved
+	}
+
+	if _, saved := run(start); len(saved) != 1 {
+		t.Fatalf("first run saved %d documents, want 1", len(saved))
+	}
+
+	docs, saved := run(start.Add(time.Minute))
+	if len(docs) != 1 || !docs[0].NotModified || len(saved) != 0 {
+		t.Fatalf("unchanged file: fetched %+v, saved %d; want a not-modified re-check", docs, len(saved))
+	}
+
+	mu.Lock()
+	version, etag = "Circular version 2", `"v2"`
+	mu.Unlock()
+	docs, saved = run(start.Add(2 * time.Minute))
+	if len(saved) != 1 || string(saved[0].Body) != "Circular version 2" {
+		t.Fatalf("replaced file: fetched %d, saved %d; want the new version recorded", len(docs), len(saved))
+	}
+
+	state, err := storage.GetDocumentState(context.Background(), src.ID, server.URL+"/kyc.pdf")
+	if err != nil || state == nil || state.ETag != `"v2"` {
+		t.Fatalf("document state = %+v, %v; want the new version's validators", state, err)
+	}
+
+	// Within the recheck interval known files are not requested at all
+	src.AttachmentRecheck = time.Hour
+	if docs := h.FetchAttachments(context.Background(), src, page); len(docs) != 0 {
+		t.Errorf("fetched %d documents within the recheck interval, want none", len(docs))
+	}
+
+	want := []string{"", `"v1"`, `"v1"`}
+	mu.Lock()
+	defer mu.Unlock()
+	if strings.Join(conditional, ",") != strings.Join(want, ",") {
+		t.Errorf("If-None-Match sent = %q, want %q", conditional, want)
+	}
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	// SMTP connection security
+	EmailSecuritySTARTTLS = "starttls" // upgrade a plain connection, usually port 587
+	EmailSecurityTLS      = "tls"      // TLS from the start, usually port 465
+	EmailSecurityNone     = "none"     // plain text, only for a local relay
+
+	// defaultSMTPTimeout bounds a whole SMTP exchange
+	defaultSMTPTimeout = 30 * time.Second
+)
+
+// EmailNotifier mails each notification to a fixed list of recipients
+type EmailNotifier struct {
+	host       string
+	port       int
+	security   string
+	auth       smtp.Auth
+	from       string // as shown in the From header
+	sender     string // bare address for the envelope
+	recipients []string
+	timeout    time.Duration
+	tlsConfig  *tls.Config
+}
+
+// NewEmailNotifier creates an email notifier from its configuration
+func NewEmailNotifier(cfg EmailConfig) (*EmailNotifier, error) {
+	if cfg.SMTPServer == "" {
+		return nil, errors.New("smtp_server is required")
+	}
+	if len(cfg.Recipients) == 0 {
+		return nil, errors.New("no recipients")
+	}
+
+	port := cfg.SMTPPort
+	if port <= 0 {
+		port = 587
+	}
+
+	security := strings.ToLower(cfg.Security)
+	switch security {
+	case "":
+		security = EmailSecuritySTARTTLS
+		if port == 465 {
+			security = EmailSecurityTLS
+		}
+	case EmailSecuritySTARTTLS, EmailSecurityTLS, EmailSecurityNone:
+	default:
+		return nil, fmt.Errorf("unknown security %q", cfg.Security)
+	}
+
+	from := cfg.From
+	if from == "" {
+		from = cfg.Username
+	}
+	if from == "" {
+		return nil, errors.New("from or username is required")
+	}
+	sender, err := mail.ParseAddress(from)
+	if err != nil {
+		return nil, fmt.Errorf("invalid from address %q: %w", from, err)
+	}
+	for _, rcpt := range cfg.Recipients {
+		if _, err := mail.ParseAddress(rcpt); err != nil {
+			return nil, fmt.Errorf("invalid recipient %q: %w", rcpt, err)
+		}
+	}
+
+	timeout := defaultSMTPTimeout
+	if d, err := time.ParseDuration(cfg.Timeout); err == nil && d > 0 {
+		timeout = d
+	}
+
+	e := &EmailNotifier{
+		host:       cfg.SMTPServer,
+		port:       port,
+		security:   security,
+		from:       from,
+		sender:     sender.Address,
+		recipients: cfg.Recipients,
+		timeout:    timeout,
+	}
+	if cfg.Username != "" {
+		e.auth = smtp.PlainAuth("", cfg.Username, cfg.Password, cfg.SMTPServer)
+	}
+	return e, nil
+}
+
+// SetTLSConfig sets the TLS settings used to verify the server, e.g. to
+// trust a private CA. The server name defaults to smtp_server.
+func (e *EmailNotifier) SetTLSConfig(cfg *tls.Config) {
+	e.tlsConfig = cfg
+}
+
+// Name identifies the notifier in logs
+func (e *EmailNotifier) Name() string {
+	return "email"
+}
+
+// Target lists the recipients
+func (e *EmailNotifier) Target() string {
+	return strings.Join(e.recipients, ", ")
+}
+
+// Handles reports whether a notification is mailed: only new versions are
+func (e *EmailNotifier) Handles(n Notification) bool {
+	return n.Event == EventUpdate
+}
+
+// Notify mails a notification to every recipient
+func (e *EmailNotifier) Notify(ctx context.Context, n Notification) error {
+	msg, err := buildEmail(e.from, e.recipients, n, time.Now())
+	if err != nil {
+		return err
+	}
+	return e.send(ctx, e.recipients, msg)
+}
+
+// send delivers a message over a single SMTP session
+func (e *EmailNotifier) send(ctx context.Context, recipients []string, msg []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, e.timeout)
+	defer cancel()
+
+	addr := net.JoinHostPort(e.host, strconv.Itoa(e.port))
+	var conn net.Conn
+	var err error
+	if e.security == EmailSecurityTLS {
+		dialer := &tls.Dialer{Config: e.clientTLSConfig()}
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	} else {
+		var dialer net.Dialer
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %w", addr, err)
+	}
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+
+	client, err := smtp.NewClient(conn, e.host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to start SMTP session: %w", err)
+	}
+	defer client.Close()
+
+	if e.security == EmailSecuritySTARTTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("%s does not offer STARTTLS", addr)
+		}
+		if err := client.StartTLS(e.clientTLSConfig()); err != nil {
+			return fmt.Errorf("STARTTLS failed: %w", err)
+		}
+	}
+
+	if e.auth != nil {
+		if err := client.Auth(e.auth); err != nil {
+			return fmt.Errorf("SMTP authentication failed: %w", err)
+		}
+	}
+
+	if err := client.Mail(e.sender); err != nil {
+		return fmt.Errorf("MAIL FROM rejected: %w", err)
+	}
+	for _, rcpt := range recipients {
+		// Recipients were validated when the notifier was created
+		addr, _ := mail.ParseAddress(rcpt)
+		if err := client.Rcpt(addr.Address); err != nil {
+			return fmt.Errorf("recipient %s rejected: %w", rcpt, err)
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("DATA rejected: %w", err)
+	}
+	if _, err := w.Write(msg); err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("message rejected: %w", err)
+	}
+
+	return client.Quit()
+}
+
+// clientTLSConfig returns the TLS settings for a connection to the server
+func (e *EmailNotifier) clientTLSConfig() *tls.Config {
+	cfg := &tls.Config{}
+	if e.tlsConfig != nil {
+		cfg = e.tlsConfig.Clone()
+	}
+	if cfg.ServerName == "" {
+		cfg.ServerName = e.host
+	}
+	return cfg
+}
+
+// emailText is the plain-text part of a notification email
+var emailText = template.Must(template.New("text").Parse(`{{.Headline}}
+
+Source:   {{.SourceName}} ({{.SourceID}})
+{{- if .Category}}
+Category: {{.Category}}{{end}}
+URL:      {{.URL}}
+Detected: {{.FetchedAt.Format "2006-01-02 15:04:05 MST"}}
+{{- if .Title}}
+Title:    {{.Title}}{{end}}
+{{if .Summary}}
+{{.Summary}}
+{{end}}
+{{- with .Change}}
+What changed: +{{.Added}} / -{{.Removed}} lines
+{{range .Blocks}}
+{{range .Removed}}- {{.}}
+{{end}}{{range .Added}}+ {{.}}
+{{end}}{{end}}{{if .Truncated}}
+More changes not shown; run "legitrack diff {{$.SourceID}}" for the full diff.
+{{end}}{{end}}`))
+
+// emailHTML is the HTML part of a notification email
+var emailHTML = htmltemplate.Must(htmltemplate.New("html").Parse(`<!DOCTYPE html>
+<html>
+<body style="font-family: sans-serif; line-height: 1.5; color: #333;">
+<h2 style="color: #667eea;">{{.Headline}}</h2>
+<table style="border-collapse: collapse;">
+<tr><td style="padding-right: 12px; color: #666;">Source</td><td>{{.SourceName}} ({{.SourceID}})</td></tr>
+{{if .Category}}<tr><td style="padding-right: 12px; color: #666;">Category</td><td>{{.Category}}</td></tr>{{end}}
+<tr><td style="padding-right: 12px; color: #666;">URL</td><td><a href="{{.URL}}">{{.URL}}</a></td></tr>
+<tr><td style="padding-right: 12px; color: #666;">Detected</td><td>{{.FetchedAt.Format "2006-01-02 15:04:05 MST"}}</td></tr>
+{{if .Title}}<tr><td style="padding-right: 12px; color: #666;">Title</td><td>{{.Title}}</td></tr>{{end}}
+</table>
+{{if .Summary}}<p>{{.Summary}}</p>{{end}}
+{{with .Change}}
+<div style="background: #f8f9fa; border-radius: 5px; padding: 10px 15px;">
+<div style="color: #666;">What changed: +{{.Added}} / -{{.Removed}} lines</div>
+{{range .Blocks}}<div style="margin-top: 8px;">
+{{range .Removed}}<div style="color: #dc3545;"><del>- {{.}}</del></div>{{end}}
+{{range .Added}}<div style="color: #28a745;">+ {{.}}</div>{{end}}
+</div>{{end}}
+{{if .Truncated}}<div style="margin-top: 8px; color: #666;">More changes not shown; run <code>legitrack diff {{$.SourceID}}</code> for the full diff.</div>{{end}}
+</div>
+{{end}}
+</body>
+</html>
+`))
+
+// buildEmail renders a notification as a multipart/alternative message with
+// a plain-text and an HTML part
+func buildEmail(from string, to []string, n Notification, now time.Time) ([]byte, error) {
+	var text, html bytes.Buffer
+	if err := emailText.Execute(&text, n); err != nil {
+		return nil, fmt.Errorf("failed to render email text: %w", err)
+	}
+	if err := emailHTML.Execute(&html, n); err != nil {
+		return nil, fmt.Errorf("failed to render email HTML: %w", err)
+	}
+
+	// The same for every delivery, so a resent copy threads as a duplicate
+	messageID := fmt.Sprintf("<%s@legitrack>", n.Key())
+	return composeEmail(from, to, n.Subject(), messageID, text.Bytes(), html.Bytes(), now)
+}
+
+// composeEmail assembles a multipart/alternative message from its rendered
+// plain-text and HTML parts
+func composeEmail(from string, to []string, subject, messageID string, text, html []byte, now time.Time) ([]byte, error) {
+	var body bytes.Buffer
+	parts := multipart.NewWriter(&body)
+	for _, part := range []struct {
+		contentType string
+		content     []byte
+	}{
+		{"text/plain; charset=utf-8", text},
+		{"text/html; charset=utf-8", html},
+	} {
+		w, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(w)
+		if _, err := qp.Write(part.content); err != nil {
+			return nil, err
+		}
+		if err := qp.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if err := parts.Close(); err != nil {
+		return nil, err
+	}
+
+	var msg bytes.Buffer
+	header := []struct{ key, value string }{
+		{"From", from},
+		{"To", strings.Join(to, ", ")},
+		{"Subject", mime.QEncoding.Encode("utf-8", subject)},
+		{"Date", now.Format(time.RFC1123Z)},
+		{"Message-ID", messageID},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", "multipart/alternative; boundary=" + parts.Boundary()},
+	}
+	for _, h := range header {
+		fmt.Fprintf(&msg, "%s: %s\r\n", h.key, h.value)
+	}
+	msg.WriteString("\r\n")
+	msg.Write(body.Bytes())
+	return msg.Bytes(), nil
+}
@@ -0,0 +1,454 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/http/httptest"
+	"net/mail"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// smtpMessage is a message accepted by the SMTP stand-in
+type smtpMessage struct {
+	from string
+	to   []string
+	data []byte
+	tls  bool   // sent after STARTTLS
+	auth string // decoded AUTH PLAIN credentials, if any
+}
+
+// smtpStandIn is a minimal in-process SMTP server. It offers STARTTLS when
+// it has a TLS config, and answers RCPT for the addresses in reject with
+// the given reply code.
+type smtpStandIn struct {
+	ln        net.Listener
+	tlsConfig *tls.Config
+	reject    map[string]int
+
+	mu       sync.Mutex
+	messages []smtpMessage
+}
+
+func newSMTPStandIn(t *testing.T, tlsConfig *tls.Config) *smtpStandIn {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &smtpStandIn{ln: ln, tlsConfig: tlsConfig, reject: make(map[string]int)}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+// config returns an email configuration pointing at the stand-in
+func (s *smtpStandIn) config(security string) EmailConfig {
+	return EmailConfig{
+		Enabled:    true,
+		SMTPServer: "127.0.0.1",
+		SMTPPort:   s.ln.Addr().(*net.TCPAddr).Port,
+		Security:   security,
+		From:       "LegiTrack <alerts@legitrack.example>",
+		Recipients: []string{"Compliance <compliance@example.com>", "legal@example.com"},
+		Timeout:    "5s",
+	}
+}
+
+func (s *smtpStandIn) received() []smtpMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]smtpMessage(nil), s.messages...)
+}
+
+func (s *smtpStandIn) serve(conn net.Conn) {
+	defer func() { conn.Close() }()
+	tp := textproto.NewConn(conn)
+	tp.PrintfLine("220 stand-in ESMTP")
+
+	var msg smtpMessage
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO":
+			extensions := []string{"stand-in"}
+			if s.tlsConfig != nil && !msg.tls {
+				extensions = append(extensions, "STARTTLS")
+			}
+			if msg.tls || s.tlsConfig == nil {
+				extensions = append(extensions, "AUTH PLAIN")
+			}
+			for i, ext := range extensions {
+				sep := "-"
+				if i == len(extensions)-1 {
+					sep = " "
+				}
+				tp.PrintfLine("250%s%s", sep, ext)
+			}
+		case "STARTTLS":
+			if s.tlsConfig == nil {
+				tp.PrintfLine("502 not offered")
+				continue
+			}
+			tp.PrintfLine("220 ready to start TLS")
+			tlsConn := tls.Server(conn, s.tlsConfig)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn = tlsConn
+			tp = textproto.NewConn(conn)
+			msg = smtpMessage{tls: true}
+		case "AUTH":
+			_, initial, _ := strings.Cut(arg, " ")
+			creds, _ := base64.StdEncoding.DecodeString(initial)
+			msg.auth = strings.ReplaceAll(string(creds), "\x00", " ")
+			tp.PrintfLine("235 authenticated")
+		case "MAIL":
+			msg.from = strings.Trim(arg[len("FROM:"):], "<>")
+			tp.PrintfLine("250 sender ok")
+		case "RCPT":
+			to := strings.Trim(arg[len("TO:"):], "<>")
+			if code, ok := s.reject[to]; ok {
+				tp.PrintfLine("%d recipient %s rejected", code, to)
+				continue
+			}
+			msg.to = append(msg.to, to)
+			tp.PrintfLine("250 recipient ok")
+		case "DATA":
+			tp.PrintfLine("354 go ahead")
+			data, err := tp.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			msg.data = data
+			s.mu.Lock()
+			s.messages = append(s.messages, msg)
+			s.mu.Unlock()
+			msg = smtpMessage{tls: msg.tls, auth: msg.auth}
+			tp.PrintfLine("250 queued")
+		case "RSET", "NOOP":
+			tp.PrintfLine("250 ok")
+		case "QUIT":
+			tp.PrintfLine("221 bye")
+			return
+		default:
+			tp.PrintfLine("500 unknown command")
+		}
+	}
+}
+
+// testTLS returns a server certificate for 127.0.0.1 and a client config
+// trusting it
+func testTLS(t *testing.T) (server, client *tls.Config) {
+	t.Helper()
+	srv := httptest.NewUnstartedServer(nil)
+	srv.StartTLS()
+	defer srv.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(srv.Certificate())
+	return &tls.Config{Certificates: srv.TLS.Certificates}, &tls.Config{RootCAs: pool}
+}
+
+// testNotification is a new version of a page with a change excerpt
+func testNotification() Notification {
+	return Notification{
+		Event: EventUpdate,
+		Update: Update{
+			SourceID:  "rbi_regulations",
+			URL:       "https://rbi.example/circulars",
+			FetchedAt: time.Date(2026, 3, 14, 9, 30, 0, 0, time.UTC),
+			Hash:      "3f7a2b9c1d4e5f60718293a4b5c6d7e8f90a1b2c3d4e5f60718293a4b5c6d7e8",
+			Title:     "Master Circular – KYC Directions",
+			Summary:   "The periodic KYC update deadline moved to 30 June.",
+		},
+		SourceName: "RBI Banking Regulations",
+		Category:   "financial_regulations",
+		Severity:   SeverityMedium,
+		Change: &ChangeExcerpt{
+			Added:   1,
+			Removed: 1,
+			Blocks: []ChangeBlock{{
+				Removed: []string{"Periodic updation is due by 31 March 2026."},
+				Added:   []string{"Periodic updation is due by 30 June 2026."},
+			}},
+		},
+	}
+}
+
+// parsedEmail is a received message split into its headers and parts
+type parsedEmail struct {
+	header mail.Header
+	text   string
+	html   string
+}
+
+func parseEmail(t *testing.T, data []byte) parsedEmail {
+	t.Helper()
+	msg, err := mail.ReadMessage(bufio.NewReader(strings.NewReader(string(data))))
+	if err != nil {
+		t.Fatalf("unparseable message: %v", err)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("Content-Type = %q, want multipart/alternative", msg.Header.Get("Content-Type"))
+	}
+
+	parsed := parsedEmail{header: msg.Header}
+	parts := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		part, err := parts.NextRawPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("bad part: %v", err)
+		}
+		if enc := part.Header.Get("Content-Transfer-Encoding"); enc != "quoted-printable" {
+			t.Errorf("part encoding = %q, want quoted-printable", enc)
+		}
+		body, err := io.ReadAll(quotedprintable.NewReader(part))
+		if err != nil {
+			t.Fatalf("bad quoted-printable: %v", err)
+		}
+
+		switch part.Header.Get("Content-Type") {
+		case "text/plain; charset=utf-8":
+			parsed.text = string(body)
+		case "text/html; charset=utf-8":
+			parsed.html = string(body)
+		default:
+			t.Errorf("unexpected part %q", part.Header.Get("Content-Type"))
+		}
+	}
+	return parsed
+}
+
+func TestEmailNotifierSendsMultipartMessage(t *testing.T) {
+	server := newSMTPStandIn(t, nil)
+	notifier, err := NewEmailNotifier(server.config(EmailSecurityNone))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n := testNotification()
+	if err := notifier.Notify(context.Background(), n); err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+
+	messages := server.received()
+	if len(messages) != 1 {
+		t.Fatalf("received %d messages, want 1", len(messages))
+	}
+	msg := messages[0]
+	if msg.from != "alerts@legitrack.example" {
+		t.Errorf("MAIL FROM = %q, want the bare sender address", msg.from)
+	}
+	if got := strings.Join(msg.to, ","); got != "compliance@example.com,legal@example.com" {
+		t.Errorf("RCPT TO = %s, want both recipients' bare addresses", got)
+	}
+
+	email := parseEmail(t, msg.data)
+	subject, err := new(mime.WordDecoder).DecodeHeader(email.header.Get("Subject"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	headers := map[string]string{
+		"From":         "LegiTrack <alerts@legitrack.example>",
+		"To":           "Compliance <compliance@example.com>, legal@example.com",
+		"Subject":      "[LegiTrack] New content: RBI Banking Regulations - Master Circular – KYC Directions",
+		"Message-ID":   "<" + n.Key() + "@legitrack>",
+		"MIME-Version": "1.0",
+	}
+	for key, want := range headers {
+		got := email.header.Get(key)
+		if key == "Subject" {
+			got = subject
+		}
+		if got != want {
+			t.Errorf("%s = %q, want %q", key, got, want)
+		}
+	}
+	if _, err := email.header.Date(); err != nil {
+		t.Errorf("bad Date header: %v", err)
+	}
+
+	for _, want := range []string{
+		"New content: RBI Banking Regulations",
+		"Source:   RBI Banking Regulations (rbi_regulations)",
+		"Category: financial_regulations",
+		"URL:      https://rbi.example/circulars",
+		"Detected: 2026-03-14 09:30:00 UTC",
+		"The periodic KYC update deadline moved to 30 June.",
+		"What changed: +1 / -1 lines",
+		"- Periodic updation is due by 31 March 2026.",
+		"+ Periodic updation is due by 30 June 2026.",
+	} {
+		if !strings.Contains(email.text, want) {
+			t.Errorf("text part lacks %q:\n%s", want, email.text)
+		}
+	}
+
+	for _, want := range []string{
+		`<h2 style="color: #667eea;">New content: RBI Banking Regulations</h2>`,
+		`<a href="https://rbi.example/circulars">https://rbi.example/circulars</a>`,
+		`<del>- Periodic updation is due by 31 March 2026.</del>`,
+		`+ Periodic updation is due by 30 June 2026.`,
+	} {
+		if !strings.Contains(email.html, want) {
+			t.Errorf("HTML part lacks %q:\n%s", want, email.html)
+		}
+	}
+}
+
+func TestEmailNotifierSTARTTLS(t *testing.T) {
+	serverTLS, clientTLS := testTLS(t)
+	server := newSMTPStandIn(t, serverTLS)
+
+	cfg := server.config(EmailSecuritySTARTTLS)
+	cfg.Username = "alerts@legitrack.example"
+	cfg.Password = "s3cret"
+	notifier, err := NewEmailNotifier(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	notifier.SetTLSConfig(clientTLS)
+
+	if err := notifier.Notify(context.Background(), testNotification()); err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+
+	messages := server.received()
+	if len(messages) != 1 {
+		t.Fatalf("received %d messages, want 1", len(messages))
+	}
+	if !messages[0].tls {
+		t.Error("message was sent before STARTTLS")
+	}
+	if want := " alerts@legitrack.example s3cret"; messages[0].auth != want {
+		t.Errorf("AUTH PLAIN = %q, want %q", messages[0].auth, want)
+	}
+}
+
+func TestEmailNotifierSTARTTLSUntrusted(t *testing.T) {
+	serverTLS, _ := testTLS(t)
+	server := newSMTPStandIn(t, serverTLS)
+	notifier, err := NewEmailNotifier(server.config(EmailSecuritySTARTTLS))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = notifier.Notify(context.Background(), testNotification())
+	if err == nil || !strings.Contains(err.Error(), "STARTTLS failed") {
+		t.Fatalf("Notify error = %v, want the certificate to be rejected", err)
+	}
+	if len(server.received()) != 0 {
+		t.Error("message was sent over an unverified connection")
+	}
+}
+
+func TestEmailNotifierRequiresSTARTTLS(t *testing.T) {
+	server := newSMTPStandIn(t, nil)
+	notifier, err := NewEmailNotifier(server.config(EmailSecuritySTARTTLS))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = notifier.Notify(context.Background(), testNotification())
+	if err == nil || !strings.Contains(err.Error(), "does not offer STARTTLS") {
+		t.Fatalf("Notify error = %v, want STARTTLS to be required", err)
+	}
+	if len(server.received()) != 0 {
+		t.Error("message was sent in plain text")
+	}
+}
+
+func TestEmailNotifierRejectedRecipient(t *testing.T) {
+	tests := []struct {
+		code      int
+		retryable bool
+	}{
+		{550, false}, // no such mailbox
+		{451, true},  // greylisted
+	}
+	for _, tt := range tests {
+		t.Run(strconv.Itoa(tt.code), func(t *testing.T) {
+			server := newSMTPStandIn(t, nil)
+			server.reject["legal@example.com"] = tt.code
+			notifier, err := NewEmailNotifier(server.config(EmailSecurityNone))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = notifier.Notify(context.Background(), testNotification())
+			var reply *textproto.Error
+			if !errors.As(err, &reply) || reply.Code != tt.code {
+				t.Fatalf("Notify error = %v, want the %d reply", err, tt.code)
+			}
+			if got := retryableDelivery(err); got != tt.retryable {
+				t.Errorf("retryableDelivery = %v, want %v", got, tt.retryable)
+			}
+			if len(server.received()) != 0 {
+				t.Error("message was sent despite the rejection")
+			}
+		})
+	}
+}
+
+func TestNewEmailNotifierValidation(t *testing.T) {
+	valid := EmailConfig{SMTPServer: "smtp.example.com", From: "alerts@example.com", Recipients: []string{"a@example.com"}}
+
+	tests := map[string]func(*EmailConfig){
+		"no server":        func(c *EmailConfig) { c.SMTPServer = "" },
+		"no recipients":    func(c *EmailConfig) { c.Recipients = nil },
+		"no sender":        func(c *EmailConfig) { c.From = "" },
+		"bad sender":       func(c *EmailConfig) { c.From = "alerts" },
+		"bad recipient":    func(c *EmailConfig) { c.Recipients = []string{"a@example.com", "not an address"} },
+		"unknown security": func(c *EmailConfig) { c.Security = "ssl" },
+	}
+	for name, mutate := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg := valid
+			mutate(&cfg)
+			if _, err := NewEmailNotifier(cfg); err == nil {
+				t.Error("NewEmailNotifier accepted an invalid configuration")
+			}
+		})
+	}
+
+	notifier, err := NewEmailNotifier(valid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprintf("%s:%d %s", notifier.host, notifier.port, notifier.security); got != "smtp.example.com:587 starttls" {
+		t.Errorf("defaults = %s, want port 587 with STARTTLS", got)
+	}
+}
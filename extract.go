@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// ExtractConfig describes how to split a listing page into individual items
+type ExtractConfig struct {
+	Rows        string    `yaml:"rows"` // selector matching one node per item
+	Title       FieldSpec `yaml:"title"`
+	Date        FieldSpec `yaml:"date"`
+	Link        FieldSpec `yaml:"link"`
+	Reference   FieldSpec `yaml:"reference"`
+	DateFormats []string  `yaml:"date_formats"` // Go time layouts tried in order
+}
+
+// FieldSpec locates one field within an item row. Without a selector the
+// row itself is used; without an attribute the node's text is used.
+type FieldSpec struct {
+	Selector string `yaml:"selector"`
+	Attr     string `yaml:"attr"`
+	Pattern  string `yaml:"pattern"` // optional regex; first group (or match) is kept
+}
+
+// fieldExtractor is a compiled FieldSpec
+type fieldExtractor struct {
+	selector nodeSelector
+	attr     string
+	pattern  *regexp.Regexp
+}
+
+// Extractor turns listing pages into items according to a source's spec
+type Extractor struct {
+	rows        nodeSelector
+	title       *fieldExtractor
+	date        *fieldExtractor
+	link        *fieldExtractor
+	reference   *fieldExtractor
+	dateFormats []string
+}
+
+// NewExtractor compiles an extraction spec. It returns nil when no row
+// selector is configured.
+func NewExtractor(cfg ExtractConfig) (*Extractor, error) {
+	if cfg.Rows == "" {
+		return nil, nil
+	}
+
+	rows, err := compileSelector(cfg.Rows)
+	if err != nil {
+		return nil, fmt.Errorf("rows: %w", err)
+	}
+
+	e := &Extractor{rows: rows, dateFormats: cfg.DateFormats}
+	fields := []struct {
+		name string
+		spec FieldSpec
+		dst  **fieldExtractor
+	}{
+		{"title", cfg.Title, &e.title},
+		{"date", cfg.Date, &e.date},
+		{"link", cfg.Link, &e.link},
+		{"reference", cfg.Reference, &e.reference},
+	}
+	for _, f := range fields {
+		compiled, err := compileField(f.spec)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f.name, err)
+		}
+		*f.dst = compiled
+	}
+
+	if e.title == nil && e.link == nil && e.reference == nil {
+		return nil, fmt.Errorf("at least one of title, link or reference must be configured")
+	}
+
+	return e, nil
+}
+
+// compileField compiles a field spec, returning nil for an unset field
+func compileField(spec FieldSpec) (*fieldExtractor, error) {
+	if spec == (FieldSpec{}) {
+		return nil, nil
+	}
+
+	f := &fieldExtractor{attr: spec.Attr}
+	if spec.Selector != "" {
+		sel, err := compileSelector(spec.Selector)
+		if err != nil {
+			return nil, err
+		}
+		f.selector = sel
+	}
+	if spec.Pattern != "" {
+		re, err := regexp.Compile(spec.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", spec.Pattern, err)
+		}
+		f.pattern = re
+	}
+	return f, nil
+}
+
+// value extracts the field from a row, or "" if it is absent
+func (f *fieldExtractor) value(row *html.Node) string {
+	if f == nil {
+		return ""
+	}
+
+	node := row
+	if f.selector != nil {
+		matches := f.selector.Select(row)
+		if len(matches) == 0 {
+			return ""
+		}
+		node = matches[0]
+	}
+
+	var value string
+	if f.attr != "" {
+		for _, attr := range node.Attr {
+			if attr.Key == f.attr {
+				value = attr.Val
+				break
+			}
+		}
+	} else {
+		value = nodeText(node)
+	}
+	value = strings.Join(strings.Fields(value), " ")
+
+	if f.pattern != nil {
+		m := f.pattern.FindStringSubmatch(value)
+		switch {
+		case m == nil:
+			return ""
+		case len(m) > 1:
+			return m[1]
+		default:
+			return m[0]
+		}
+	}
+	return value
+}
+
+// Extract returns the items on a listing page. Relative links are resolved
+// against pageURL. Rows without a title, link or reference are skipped.
+func (e *Extractor) Extract(body []byte, pageURL string) ([]Item, error) {
+	doc, err := html.Parse(bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse HTML: %w", err)
+	}
+
+	base, _ := url.Parse(pageURL)
+
+	var items []Item
+	seen := make(map[string]bool)
+	for _, row := range e.rows.Select(doc) {
+		item := Item{
+			Title:     e.title.value(row),
+			Link:      e.link.value(row),
+			Reference: e.reference.value(row),
+			DateText:  e.date.value(row),
+		}
+		if item.Title == "" && item.Link == "" && item.Reference == "" {
+			continue // header or spacer row
+		}
+
+		if item.Link != "" && base != nil {
+			if ref, err := url.Parse(item.Link); err == nil {
+				item.Link = base.ResolveReference(ref).String()
+			}
+		}
+		item.PublishedAt = parseItemDate(item.DateText, e.dateFormats)
+		item.ItemID = itemID(item)
+
+		// Listings often repeat an item, e.g. in a "latest" box
+		if seen[item.ItemID] {
+			continue
+		}
+		seen[item.ItemID] = true
+
+		items = append(items, item)
+	}
+
+	return items, nil
+}
+
+// itemID returns a stable identifier for an item: its reference number if it
+// has one, otherwise its link, otherwise a hash of its title and date
+func itemID(item Item) string {
+	switch {
+	case item.Reference != "":
+		return "ref:" + item.Reference
+	case item.Link != "":
+		return "url:" + item.Link
+	default:
+		sum := sha256.Sum256([]byte(item.Title + "\x00" + item.DateText))
+		return "sha:" + hex.EncodeToString(sum[:8])
+	}
+}
+
+// itemLabel describes an item in one line for logs and reports
+func itemLabel(item Item) string {
+	label := item.Title
+	if label == "" {
+		label = item.Link
+	}
+	if item.Reference != "" && !strings.Contains(label, item.Reference) {
+		if label == "" {
+			label = item.Reference
+		} else {
+			label = item.Reference + " " + label
+		}
+	}
+	if item.DateText != "" {
+		label += " (" + item.DateText + ")"
+	}
+	return label
+}
+
+// parseItemDate parses a listing date with the first matching layout
+func parseItemDate(text string, layouts []string) time.Time {
+	if text == "" {
+		return time.Time{}
+	}
+	for _, layout := range layouts {
+		if t, err := time.Parse(layout, text); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// extractItems runs src's extractor over a fetched body. Extraction problems
+// are logged rather than failing the fetch, since the page itself was fetched.
+func extractItems(src Source, body []byte) []Item {
+	if src.Extractor == nil {
+		return nil
+	}
+
+	items, err := src.Extractor.Extract(body, src.URL)
+	if err != nil {
+		log.Printf("[EXTRACT] %s: %v", src.ID, err)
+		return nil
+	}
+	if len(items) == 0 {
+		log.Printf("[EXTRACT] %s: row selector matched no items", src.ID)
+		return nil
+	}
+
+	for i := range items {
+		items[i].SourceID = src.ID
+	}
+	return items
+}
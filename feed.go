@@ -0,0 +1,379 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html/charset"
+)
+
+// Source types
+const (
+	SourceTypePage = "page"
+	SourceTypeFeed = "feed"
+)
+
+// feedAccept is sent with feed requests so servers pick a feed representation
+const feedAccept = "application/rss+xml, application/atom+xml, application/xml;q=0.9, text/xml;q=0.9, */*;q=0.8"
+
+// ItemIndex reports which items of a source were already recorded
+type ItemIndex interface {
+	HasItem(ctx context.Context, sourceID, itemID string) (bool, error)
+}
+
+// FeedEntry is a single item of an RSS or Atom feed
+type FeedEntry struct {
+	ID          string // GUID, Atom id, or link when the feed gives neither
+	Title       string
+	Link        string
+	Summary     string // HTML as published
+	PublishedAt time.Time
+}
+
+// FeedScraper polls RSS and Atom feeds, emitting one update per new entry
+type FeedScraper struct {
+	http  *HTTPScraper
+	items ItemIndex
+}
+
+// NewFeedScraper creates a feed scraper that fetches through http, sharing
+// its client, user agent, backoff and cache validators
+func NewFeedScraper(http *HTTPScraper) *FeedScraper {
+	return &FeedScraper{http: http}
+}
+
+// SetItemIndex lets the scraper skip entries that were already recorded
+func (f *FeedScraper) SetItemIndex(index ItemIndex) {
+	f.items = index
+}
+
+// Scrape fetches a feed and emits an update for each entry not seen before.
+// A feed with no new entries is recorded as a check, like a 304.
+func (f *FeedScraper) Scrape(ctx context.Context, src Source, out chan<- Update) error {
+	log.Printf("[FEED] Starting poll of %s", src.URL)
+
+	conditional, state := f.http.conditionalHeaders(ctx, src)
+	header := make(http.Header)
+	for key, values := range conditional {
+		header[key] = values
+	}
+	header.Set("Accept", feedAccept)
+
+	var resp *fetchResult
+	attempts, err := retry(ctx, src.ID, src.MaxRetries, f.http.backoff, func(attempt int) error {
+		r, err := f.http.fetch(ctx, src, src.URL, header)
+		if err != nil {
+			return err
+		}
+		resp = r
+		return nil
+	})
+	if err != nil {
+		out <- failedUpdate(src, attempts, err)
+		return fmt.Errorf("failed to fetch feed: %w", err)
+	}
+
+	etag := resp.Header.Get("ETag")
+	lastModified := resp.Header.Get("Last-Modified")
+	checked := Update{
+		SourceID:     src.ID,
+		URL:          src.URL,
+		FetchedAt:    time.Now().UTC(),
+		StatusCode:   resp.StatusCode,
+		Success:      true,
+		RetryCount:   attempts - 1,
+		NotModified:  true,
+		ETag:         etag,
+		LastModified: lastModified,
+	}
+
+	if resp.StatusCode == http.StatusNotModified && conditional != nil {
+		if checked.ETag == "" {
+			checked.ETag = state.ETag
+		}
+		if checked.LastModified == "" {
+			checked.LastModified = state.LastModified
+		}
+		out <- checked
+		log.Printf("[FEED] Not modified since last poll: %s", src.URL)
+		return nil
+	}
+
+	entries, err := parseFeed(resp.Body, src.URL)
+	if err != nil {
+		err = &fetchError{StatusCode: resp.StatusCode, Err: err}
+		out <- failedUpdate(src, attempts, err)
+		return err
+	}
+
+	// Feeds list newest first; emit oldest first so storage order follows publication
+	newEntries := 0
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		itemID := "guid:" + entry.ID
+
+		if f.items != nil {
+			known, err := f.items.HasItem(ctx, src.ID, itemID)
+			if err != nil {
+				log.Printf("[FEED] Could not check entry %s: %v", entry.ID, err)
+			} else if known {
+				continue
+			}
+		}
+
+		link := entry.Link
+		if link == "" {
+			link = src.URL
+		}
+
+		out <- Update{
+			SourceID:     src.ID,
+			URL:          link,
+			FetchedAt:    time.Now().UTC(),
+			Hash:         sha256Hex([]byte(src.ID + "\x00" + itemID)),
+			StatusCode:   resp.StatusCode,
+			Success:      true,
+			RetryCount:   attempts - 1,
+			Title:        entry.Title,
+			Summary:      entrySummary(entry),
+			ContentType:  contentType(resp.Header, resp.Body),
+			ETag:         etag,
+			LastModified: lastModified,
+			Items: []Item{{
+				SourceID:    src.ID,
+				ItemID:      itemID,
+				Title:       entry.Title,
+				Link:        entry.Link,
+				DateText:    formatEntryDate(entry.PublishedAt),
+				PublishedAt: entry.PublishedAt,
+			}},
+		}
+		newEntries++
+	}
+
+	if newEntries == 0 {
+		out <- checked
+	}
+
+	log.Printf("[FEED] Polled %s (%d entries, %d new, attempts: %d)", src.URL, len(entries), newEntries, attempts)
+	return nil
+}
+
+// entrySummary reduces an entry's HTML summary to plain text
+func entrySummary(entry FeedEntry) string {
+	if entry.Summary == "" {
+		return ""
+	}
+	text := strings.ReplaceAll(htmlVisibleText([]byte(entry.Summary)), "\n", " ")
+	return clipText(text, maxSummaryLen)
+}
+
+// formatEntryDate formats a published date for display, or "" if unknown
+func formatEntryDate(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format("2006-01-02")
+}
+
+// rssFeed covers RSS 2.0 (<rss><channel><item>) and RSS 1.0 (<rdf:RDF><item>)
+type rssFeed struct {
+	Items        []rssItem `xml:"item"`
+	ChannelItems []rssItem `xml:"channel>item"`
+}
+
+type rssItem struct {
+	Title       string `xml:"title"`
+	Link        string `xml:"link"`
+	GUID        string `xml:"guid"`
+	PubDate     string `xml:"pubDate"`
+	Date        string `xml:"http://purl.org/dc/elements/1.1/ date"`
+	Description string `xml:"description"`
+	Content     string `xml:"http://purl.org/rss/1.0/modules/content/ encoded"`
+}
+
+type atomFeed struct {
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomEntry struct {
+	ID        string     `xml:"id"`
+	Title     atomText   `xml:"title"`
+	Links     []atomLink `xml:"link"`
+	Published string     `xml:"published"`
+	Updated   string     `xml:"updated"`
+	Summary   atomText   `xml:"summary"`
+	Content   atomText   `xml:"content"`
+}
+
+type atomText struct {
+	Type  string `xml:"type,attr"`
+	Body  string `xml:",chardata"`
+	Inner string `xml:",innerxml"`
+}
+
+// String returns the text, keeping inline XHTML markup
+func (t atomText) String() string {
+	if t.Type == "xhtml" {
+		return strings.TrimSpace(t.Inner)
+	}
+	return strings.TrimSpace(t.Body)
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+}
+
+// parseFeed parses an RSS or Atom document. Relative links are resolved
+// against feedURL.
+func parseFeed(body []byte, feedURL string) ([]FeedEntry, error) {
+	root, err := feedRoot(body)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []FeedEntry
+	switch root {
+	case "rss", "RDF":
+		var feed rssFeed
+		if err := decodeFeed(body, &feed); err != nil {
+			return nil, err
+		}
+		for _, item := range append(feed.ChannelItems, feed.Items...) {
+			entries = append(entries, FeedEntry{
+				ID:          strings.TrimSpace(item.GUID),
+				Title:       firstNonEmpty(item.Title),
+				Link:        strings.TrimSpace(item.Link),
+				Summary:     strings.TrimSpace(firstNonEmptyRaw(item.Description, item.Content)),
+				PublishedAt: parseFeedDate(firstNonEmptyRaw(item.PubDate, item.Date)),
+			})
+		}
+	case "feed":
+		var feed atomFeed
+		if err := decodeFeed(body, &feed); err != nil {
+			return nil, err
+		}
+		for _, entry := range feed.Entries {
+			entries = append(entries, FeedEntry{
+				ID:          strings.TrimSpace(entry.ID),
+				Title:       firstNonEmpty(htmlVisibleText([]byte(entry.Title.String()))),
+				Link:        atomAlternate(entry.Links),
+				Summary:     firstNonEmptyRaw(entry.Summary.String(), entry.Content.String()),
+				PublishedAt: parseFeedDate(firstNonEmptyRaw(entry.Published, entry.Updated)),
+			})
+		}
+	default:
+		return nil, fmt.Errorf("not a feed: unexpected root element <%s>", root)
+	}
+
+	base, _ := url.Parse(feedURL)
+	for i := range entries {
+		entry := &entries[i]
+		if entry.Link != "" && base != nil {
+			if ref, err := url.Parse(entry.Link); err == nil {
+				entry.Link = base.ResolveReference(ref).String()
+			}
+		}
+
+		// Entries without a GUID are identified by link, then by content
+		if entry.ID == "" {
+			entry.ID = entry.Link
+		}
+		if entry.ID == "" {
+			entry.ID = sha256Hex([]byte(entry.Title + "\x00" + entry.Summary))[:16]
+		}
+	}
+
+	return entries, nil
+}
+
+// feedRoot returns the local name of a document's root element
+func feedRoot(body []byte) (string, error) {
+	decoder := newFeedDecoder(body)
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return "", fmt.Errorf("failed to parse feed: %w", err)
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			return start.Name.Local, nil
+		}
+	}
+}
+
+// decodeFeed decodes a feed document into v
+func decodeFeed(body []byte, v interface{}) error {
+	if err := newFeedDecoder(body).Decode(v); err != nil {
+		return fmt.Errorf("failed to parse feed: %w", err)
+	}
+	return nil
+}
+
+// newFeedDecoder returns a lenient decoder that understands the non-UTF-8
+// encodings feeds are often published in
+func newFeedDecoder(body []byte) *xml.Decoder {
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	decoder.Strict = false
+	decoder.Entity = xml.HTMLEntity
+	decoder.CharsetReader = charset.NewReaderLabel
+	return decoder
+}
+
+// atomAlternate picks an entry's alternate link, the page it describes
+func atomAlternate(links []atomLink) string {
+	for _, link := range links {
+		if link.Rel == "" || link.Rel == "alternate" {
+			return strings.TrimSpace(link.Href)
+		}
+	}
+	if len(links) > 0 {
+		return strings.TrimSpace(links[0].Href)
+	}
+	return ""
+}
+
+// firstNonEmptyRaw returns the first value that is not blank, unmodified
+func firstNonEmptyRaw(values ...string) string {
+	for _, v := range values {
+		if strings.TrimSpace(v) != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// feedDateLayouts are the date formats seen in RSS and Atom feeds
+var feedDateLayouts = []string{
+	time.RFC1123Z,
+	time.RFC1123,
+	time.RFC3339,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	"2 Jan 2006 15:04:05 -0700",
+	"Mon, 02 Jan 2006 15:04 -0700",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+// parseFeedDate parses a feed date, returning the zero time if no layout fits
+func parseFeedDate(s string) time.Time {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return time.Time{}
+	}
+	for _, layout := range feedDateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.UTC()
+		}
+	}
+	return time.Time{}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// invisibleElements never contribute to a page's visible text
+var invisibleElements = map[atom.Atom]bool{
+	atom.Head:     true,
+	atom.Script:   true,
+	atom.Style:    true,
+	atom.Noscript: true,
+	atom.Template: true,
+	atom.Iframe:   true,
+	atom.Object:   true,
+	atom.Svg:      true,
+}
+
+// blockElements start and end a line of visible text
+var blockElements = map[atom.Atom]bool{
+	atom.Address: true, atom.Article: true, atom.Aside: true, atom.Blockquote: true,
+	atom.Br: true, atom.Caption: true, atom.Dd: true, atom.Div: true, atom.Dl: true,
+	atom.Dt: true, atom.Fieldset: true, atom.Figcaption: true, atom.Figure: true,
+	atom.Footer: true, atom.Form: true, atom.H1: true, atom.H2: true, atom.H3: true,
+	atom.H4: true, atom.H5: true, atom.H6: true, atom.Header: true, atom.Hr: true,
+	atom.Li: true, atom.Main: true, atom.Nav: true, atom.Ol: true, atom.P: true,
+	atom.Pre: true, atom.Section: true, atom.Table: true, atom.Tr: true, atom.Ul: true,
+}
+
+// isHTML reports whether body looks like an HTML document
+func isHTML(body []byte) bool {
+	return strings.HasPrefix(http.DetectContentType(body), "text/html")
+}
+
+// visibleText reduces a fetched body to its visible text, one block per line.
+// Bodies that are not HTML are only whitespace-normalized.
+func visibleText(body []byte) string {
+	if !isHTML(body) {
+		return normalizeLines(string(body))
+	}
+	return htmlVisibleText(body)
+}
+
+// htmlVisibleText reduces an HTML document or fragment to its visible text
+func htmlVisibleText(body []byte) string {
+	doc, err := html.Parse(bytes.NewReader(body))
+	if err != nil {
+		return normalizeLines(string(body))
+	}
+	return nodeText(doc)
+}
+
+// nodeText returns the visible text under n, one block per line
+func nodeText(n *html.Node) string {
+	var b strings.Builder
+
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		switch n.Type {
+		case html.TextNode:
+			// Line breaks in markup are formatting, not content
+			b.WriteString(strings.Map(func(r rune) rune {
+				if unicode.IsSpace(r) {
+					return ' '
+				}
+				return r
+			}, n.Data))
+			return
+		case html.CommentNode:
+			return
+		case html.ElementNode:
+			if invisibleElements[n.DataAtom] {
+				return
+			}
+		}
+
+		block := n.Type == html.ElementNode && blockElements[n.DataAtom]
+		cell := n.Type == html.ElementNode && (n.DataAtom == atom.Td || n.DataAtom == atom.Th)
+		if block {
+			b.WriteByte('\n')
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+		if block {
+			b.WriteByte('\n')
+		} else if cell {
+			b.WriteByte(' ')
+		}
+	}
+	walk(n)
+
+	return normalizeLines(b.String())
+}
+
+// normalizeLines collapses runs of whitespace within each line and drops blank lines
+func normalizeLines(s string) string {
+	var lines []string
+	for _, line := range strings.Split(s, "\n") {
+		if line = strings.Join(strings.Fields(line), " "); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
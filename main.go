@@ -27,24 +27,123 @@ import (
 type ScraperManager struct {
 	httpScraper    *HTTPScraper
 	browserScraper *BrowserScraper
+	feedScraper    *FeedScraper
+	sitemapScraper *SitemapScraper
+	crawler        *Crawler
 }
 
 // NewScraperManager creates a new scraper manager
 func NewScraperManager() *ScraperManager {
+	httpScraper := NewHTTPScraper()
 	return &ScraperManager{
-		httpScraper:    NewHTTPScraper(),
+		httpScraper:    httpScraper,
 		browserScraper: NewBrowserScraper(),
+		feedScraper:    NewFeedScraper(httpScraper),
+		sitemapScraper: NewSitemapScraper(httpScraper),
+		crawler:        NewCrawler(httpScraper),
 	}
 }
 
-// Scrape delegates to the appropriate scraper based on source configuration
+// Scrape delegates to the appropriate scraper based on source configuration,
+// stamping each update with when work on it began: the start of the run for
+// the first update, and the previous update for the pages that follow it
 func (sm *ScraperManager) Scrape(ctx context.Context, src Source, out chan<- Update) error {
+	// One deadline for the whole run, so retries and follow-up fetches
+	// cannot stretch it past the source's timeout
+	ctx, cancel := context.WithTimeout(ctx, scrapeTimeout(src))
+	defer cancel()
+
+	stamped := make(chan Update)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		started := time.Now().UTC()
+		for update := range stamped {
+			next := time.Now().UTC()
+			update.StartedAt = started
+			out <- update
+			started = next
+		}
+	}()
+
+	err := sm.scrape(ctx, src, stamped)
+	close(stamped)
+	<-done
+	return err
+}
+
+// scrape runs the scraper for src's type
+func (sm *ScraperManager) scrape(ctx context.Context, src Source, out chan<- Update) error {
+	switch src.Type {
+	case SourceTypeFeed:
+		return sm.feedScraper.Scrape(ctx, src, out)
+	case SourceTypeSitemap:
+		return sm.sitemapScraper.Scrape(ctx, src, out)
+	case SourceTypeCrawl:
+		return sm.crawler.Scrape(ctx, src, out)
+	}
 	if src.JSRendered {
 		return sm.browserScraper.Scrape(ctx, src, out)
 	}
 	return sm.httpScraper.Scrape(ctx, src, out)
 }
 
+// recordItems stores the items extracted from an update and logs the new ones
+func recordItems(ctx context.Context, storage Storage, update Update) {
+	if len(update.Items) == 0 {
+		return
+	}
+
+	newItems, err := storage.SaveItems(ctx, update.SourceID, update.Items, update.FetchedAt)
+	if err != nil {
+		log.Printf("[ORCHESTRATOR] Failed to save items for %s: %v", update.SourceID, err)
+		return
+	}
+	if len(newItems) == 0 {
+		return
+	}
+
+	log.Printf("[ORCHESTRATOR] %d new items for %s", len(newItems), update.SourceID)
+	for _, item := range newItems {
+		log.Printf("[ORCHESTRATOR]   %s: %s", update.SourceID, itemLabel(item))
+	}
+}
+
+// recordRun logs a fetch and its outcome in the scrape_runs table
+func recordRun(ctx context.Context, storage Storage, update Update, outcome string) {
+	started := update.StartedAt
+	if started.IsZero() {
+		started = update.FetchedAt
+	}
+
+	run := ScrapeRun{
+		SourceID:   update.SourceID,
+		URL:        update.URL,
+		StartedAt:  started,
+		FinishedAt: update.FetchedAt,
+		Duration:   update.FetchedAt.Sub(started),
+		StatusCode: update.StatusCode,
+		Bytes:      len(update.Body),
+		Outcome:    outcome,
+		Attempts:   update.RetryCount + 1,
+		Error:      update.ErrorDetail,
+	}
+	if err := storage.SaveScrapeRun(ctx, run); err != nil {
+		log.Printf("[ORCHESTRATOR] Failed to record run of %s: %v", update.SourceID, err)
+	}
+}
+
+// recordDocuments stores the attachments fetched with an update
+func recordDocuments(ctx context.Context, storage Storage, update Update) {
+	saved, err := storage.SaveDocuments(ctx, update)
+	if err != nil {
+		log.Printf("[ORCHESTRATOR] Failed to save documents for %s: %v", update.SourceID, err)
+	}
+	for _, doc := range saved {
+		log.Printf("[ORCHESTRATOR] New document for %s: %s (%d pages)", update.SourceID, doc.URL, doc.Pages)
+	}
+}
+
 func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -52,10 +151,7 @@ func main() {
 	log.Println("Starting LegiTrack web scraper...")
 
 	// Load configuration
-	configPath := "config.yaml"
-	if len(os.Args) > 1 {
-		configPath = os.Args[1]
-	}
+	configPath, command, cmdArgs := parseArgs(os.Args[1:])
 
 	config, err := LoadConfig(configPath)
 	if err != nil {
@@ -64,8 +160,21 @@ func main() {
 
 	log.Printf("Configuration loaded from %s", configPath)
 
+	// Schema commands run before storage is opened, which applies migrations
+	if command == "db" {
+		if err := runDBCommand(ctx, config.GetDatabasePath(), cmdArgs); err != nil {
+			log.Fatalf("Database command failed: %v", err)
+		}
+		return
+	}
+
 	// Initialize storage
-	storage, err := NewSQLiteStorage(config.GetDatabasePath())
+	blobs, err := NewFileBlobStore(config.GetBlobDirectory(), config.Storage.CompressBodies)
+	if err != nil {
+		log.Fatalf("Failed to initialize blob store: %v", err)
+	}
+
+	storage, err := NewSQLiteStorage(config.GetDatabasePath(), blobs)
 	if err != nil {
 		log.Fatalf("Failed to initialize storage: %v", err)
 	}
@@ -75,10 +184,10 @@ func main() {
 	reporter := NewReporter(storage, config, config.GetReportingOutputDir())
 
 	// Check if this is a report generation command
-	if len(os.Args) > 2 && os.Args[2] == "report" {
-		if len(os.Args) > 3 {
+	if command == "report" {
+		if len(cmdArgs) > 0 {
 			// Generate report for specific date
-			dateStr := os.Args[3]
+			dateStr := cmdArgs[0]
 			date, err := time.Parse("2006-01-02", dateStr)
 			if err != nil {
 				log.Fatalf("Invalid date format. Use YYYY-MM-DD: %v", err)
@@ -99,43 +208,174 @@ func main() {
 		}
 	}
 
+	// Check if this is a diff command
+	if command == "diff" {
+		if err := runDiffCommand(ctx, storage, config, cmdArgs); err != nil {
+			log.Fatalf("Failed to diff versions: %v", err)
+		}
+		return
+	}
+
+	// Check if this is a notify command
+	if command == "notify" {
+		if err := runNotifyCommand(ctx, storage, cmdArgs); err != nil {
+			log.Fatalf("Notify command failed: %v", err)
+		}
+		return
+	}
+
+	if command != "" {
+		log.Fatalf("Unknown command %q", command)
+	}
+
 	// Initialize scraper manager
 	scraperManager := NewScraperManager()
 
 	// Set user agent from configuration
 	scraperManager.httpScraper.SetUserAgent(config.GetUserAgent())
+	scraperManager.httpScraper.SetBackoff(config.GetBackoff())
+	scraperManager.httpScraper.SetTimeouts(config.GetHTTPTimeouts())
+	scraperManager.httpScraper.SetValidatorStore(storage)
+	scraperManager.httpScraper.SetRobotsCache(NewRobotsCache(config.GetRobotsTTL()))
+
+	// Requests to a host share one token bucket across all sources and scrapers
+	limiter := NewHostLimiter(config.GetRateLimit())
+	scraperManager.httpScraper.SetHostLimiter(limiter)
+	scraperManager.httpScraper.SetDocumentIndex(storage)
+	scraperManager.feedScraper.SetItemIndex(storage)
+	scraperManager.sitemapScraper.SetSitemapStore(storage)
+	scraperManager.crawler.SetCrawlFrontier(storage)
+
+	renderer, err := NewRenderer(config.Global.Renderer)
+	if err != nil {
+		log.Fatalf("Failed to configure renderer: %v", err)
+	}
+	scraperManager.browserScraper.SetRenderer(renderer)
+	scraperManager.browserScraper.SetUserAgent(config.GetUserAgent())
+	scraperManager.browserScraper.SetHostLimiter(limiter)
+	scraperManager.browserScraper.SetBackoff(config.GetBackoff())
+	scraperManager.browserScraper.SetAttachmentFetcher(scraperManager.httpScraper)
 
 	// Buffered channel for updates
 	updates := make(chan Update, 1024)
 
+	// Summaries diff against the previous version with the source's rules
+	sourcesByID := make(map[string]Source)
+	for _, src := range config.GetSources() {
+		sourcesByID[src.ID] = src
+	}
+
+	// New versions and failed checks are announced on every configured
+	// channel, through an outbox saved with each update
+	routes := newRoutes(config.Notifications)
+	outbox := NewOutbox(storage, newNotifiers(config.Notifications, routes), config.Notifications.MaxRetries)
+	outbox.Start(ctx)
+
+	// Recipients who asked for digests get the changes batched instead
+	digester := NewDigester(storage, sourcesByID, config.Notifications, routes)
+
 	// Worker goroutine to process updates
+	processed := make(chan struct{})
 	go func() {
+		defer close(processed)
 		for update := range updates {
-			// Check if we already have this content
-			if update.Hash != "" {
-				existingUpdate, exists, err := storage.GetUpdateByHash(ctx, update.Hash)
+			// A 304 only tells us the source was checked
+			if update.NotModified {
+				recordRun(ctx, storage, update, RunNotModified)
+				if err := storage.RecordCheck(ctx, update, false); err != nil {
+					log.Printf("[ORCHESTRATOR] Failed to record check: %v", err)
+				}
+				log.Printf("[ORCHESTRATOR] No change for %s (not modified)", update.SourceID)
+				continue
+			}
+
+			// A cancelled run says nothing about the source
+			if update.Cancelled {
+				recordRun(ctx, storage, update, RunCancelled)
+				log.Printf("[ORCHESTRATOR] Run of %s was cancelled", update.SourceID)
+				continue
+			}
+
+			// Compare against the latest version of this page of the source
+			if update.Success && update.Hash != "" {
+				latest, err := storage.GetLatestUpdateByURL(ctx, update.SourceID, update.URL)
 				if err != nil {
-					log.Printf("[ORCHESTRATOR] Error checking existing update: %v", err)
+					log.Printf("[ORCHESTRATOR] Error checking latest update: %v", err)
 				}
 
-				// Skip if we already have the same content and it's not newer
-				if exists && !existingUpdate.FetchedAt.Before(update.FetchedAt) {
-					log.Printf("[ORCHESTRATOR] Skipping duplicate content for %s", update.SourceID)
+				if latest != nil && latest.Hash == update.Hash {
+					recordRun(ctx, storage, update, RunUnchanged)
+					if err := storage.RecordCheck(ctx, update, false); err != nil {
+						log.Printf("[ORCHESTRATOR] Failed to record check: %v", err)
+					}
+					recordItems(ctx, storage, update)
+					recordDocuments(ctx, storage, update)
+					log.Printf("[ORCHESTRATOR] No change for %s", update.SourceID)
 					continue
 				}
+
+				// Content the page had before it last changed is a revert
+				if latest != nil {
+					_, seen, err := storage.GetUpdateByHash(ctx, update.SourceID, update.URL, update.Hash)
+					if err != nil {
+						log.Printf("[ORCHESTRATOR] Error checking earlier versions: %v", err)
+					}
+					update.Reverted = seen
+				}
+			}
+
+			outcome := RunChanged
+			switch {
+			case !update.Success:
+				outcome = RunError
+			case update.Reverted:
+				outcome = RunReverted
+			}
+			recordRun(ctx, storage, update, outcome)
+
+			summarizeChange(ctx, storage, sourcesByID[update.SourceID].Normalizer, &update)
+
+			// Notifications are saved with the update, so a crash loses neither
+			var queued []OutboxEntry
+			if outbox.Enabled() {
+				src := sourcesByID[update.SourceID]
+				n := newFailureNotification(src, update)
+				if update.Success {
+					n = newNotification(ctx, storage, src, update)
+				}
+				entries, err := outbox.Entries(n)
+				if err != nil {
+					log.Printf("[ORCHESTRATOR] %v", err)
+				}
+				queued = entries
 			}
 
 			// Save the update
-			if err := storage.SaveUpdate(ctx, update); err != nil {
+			if err := storage.SaveUpdate(ctx, update, queued...); err != nil {
 				log.Printf("[ORCHESTRATOR] Failed to save update: %v", err)
 				continue
 			}
+			if len(queued) > 0 {
+				outbox.Wake()
+			}
 
-			// Log successful processing
 			if update.Success {
+				if err := storage.RecordCheck(ctx, update, true); err != nil {
+					log.Printf("[ORCHESTRATOR] Failed to record check: %v", err)
+				}
+				recordItems(ctx, storage, update)
+				recordDocuments(ctx, storage, update)
+			}
+
+			// Log successful processing
+			switch {
+			case update.Reverted:
+				log.Printf("[ORCHESTRATOR] Content of %s reverted to an earlier version (hash: %s)",
+					update.SourceID, update.Hash[:8])
+			case update.Success:
 				log.Printf("[ORCHESTRATOR] New content detected for %s (hash: %s)",
 					update.SourceID, update.Hash[:8])
-			} else {
+			default:
 				log.Printf("[ORCHESTRATOR] Error update saved for %s: %s",
 					update.SourceID, update.ErrorDetail)
 			}
@@ -154,15 +394,19 @@ func main() {
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
+	// Scrapes run on a bounded pool; a burst of ticks queues instead of piling up
+	// Scrapes get their own context so they can be cancelled at shutdown while
+	// their updates are still saved
+	scrapeCtx, cancelScrapes := context.WithCancel(ctx)
+	defer cancelScrapes()
+	dispatcher := NewDispatcher(scraperManager, limiter, config.GetMaxConcurrentScrapes(), 2*len(sources))
+	dispatcher.SetSkipRecorder(storage)
+	dispatcher.Start(scrapeCtx, updates)
+
 	// Perform initial scrape for all sources
 	log.Println("[ORCHESTRATOR] Performing initial scrape for all sources...")
 	for _, src := range sources {
-		go func(source Source) {
-			log.Printf("[ORCHESTRATOR] Initial scrape starting for %s", source.ID)
-			if err := scraperManager.Scrape(ctx, source, updates); err != nil {
-				log.Printf("[ORCHESTRATOR] Initial scrape failed for %s: %v", source.ID, err)
-			}
-		}(src)
+		dispatcher.Submit(src, "initial")
 	}
 
 	// Start the scheduler with seconds support for testing
@@ -173,14 +417,8 @@ func main() {
 		source := src // Capture for closure
 
 		entryID, err := scheduler.AddFunc(source.Cron, func() {
-			log.Printf("[ORCHESTRATOR] Scheduled scrape starting for %s", source.ID)
-
-			// Run scrape in a goroutine to avoid blocking the scheduler
-			go func() {
-				if err := scraperManager.Scrape(ctx, source, updates); err != nil {
-					log.Printf("[ORCHESTRATOR] Scheduled scrape failed for %s: %v", source.ID, err)
-				}
-			}()
+			// Submit never blocks, so the scheduler is not held up
+			dispatcher.Submit(source, "scheduled")
 		})
 
 		if err != nil {
@@ -204,6 +442,30 @@ func main() {
 		log.Println("[ORCHESTRATOR] Scheduled daily report generation at 23:59")
 	}
 
+	// Send digests on the hour and at the configured time each day
+	digests := []struct{ mode, spec string }{
+		{DeliveryHourly, "0 0 * * * *"},
+		{DeliveryDaily, config.GetDailyDigestCron()},
+	}
+	for _, digest := range digests {
+		if !digester.Enabled(digest.mode) {
+			continue
+		}
+		mode := digest.mode
+		if _, err := scheduler.AddFunc(digest.spec, func() {
+			digester.Send(ctx, mode, time.Now())
+		}); err != nil {
+			log.Printf("[ORCHESTRATOR] Failed to schedule %s digests: %v", mode, err)
+		} else {
+			log.Printf("[ORCHESTRATOR] Scheduled %s digests with cron '%s'", mode, digest.spec)
+		}
+	}
+
+	// Log queueing metrics every hour
+	if _, err := scheduler.AddFunc("0 0 * * * *", dispatcher.LogStats); err != nil {
+		log.Printf("[ORCHESTRATOR] Failed to schedule dispatcher metrics: %v", err)
+	}
+
 	// Start the scheduler
 	scheduler.Start()
 	log.Println("[ORCHESTRATOR] Scheduler started successfully")
@@ -219,6 +481,25 @@ func main() {
 		} else {
 			log.Printf("[ORCHESTRATOR] %s: No previous updates found", src.ID)
 		}
+
+		state, err := storage.GetSourceState(ctx, src.ID)
+		if err != nil {
+			log.Printf("[ORCHESTRATOR] Could not get check state for %s: %v", src.ID, err)
+		} else if state != nil {
+			lastChecked, lastChanged := "never", "never"
+			if !state.LastCheckedAt.IsZero() {
+				lastChecked = state.LastCheckedAt.Format(time.RFC3339)
+			}
+			if !state.LastChangedAt.IsZero() {
+				lastChanged = state.LastChangedAt.Format(time.RFC3339)
+			}
+			log.Printf("[ORCHESTRATOR] %s: Last checked %s, last changed %s",
+				src.ID, lastChecked, lastChanged)
+			if state.SkippedRuns > 0 {
+				log.Printf("[ORCHESTRATOR] %s: %d runs skipped, last at %s",
+					src.ID, state.SkippedRuns, state.LastSkippedAt.Format(time.RFC3339))
+			}
+		}
 	}
 
 	// Wait for shutdown signal
@@ -229,14 +510,27 @@ func main() {
 	scheduler.Stop()
 	log.Println("[ORCHESTRATOR] Scheduler stopped")
 
-	// Wait for any ongoing scrapes to complete
-	time.Sleep(10 * time.Second)
+	// Give running scrapes 10 seconds to finish, then cancel them; queued ones are dropped
+	stopped := make(chan struct{})
+	go func() {
+		dispatcher.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(10 * time.Second):
+		cancelScrapes()
+		<-stopped
+	}
+	dispatcher.LogStats()
 
 	// Close the updates channel
 	close(updates)
 
-	// Wait for the update processor to finish
-	time.Sleep(2 * time.Second)
+	// Wait for the update processor to finish and for the delivery under
+	// way; undelivered notifications stay in the outbox for the next run
+	<-processed
+	outbox.Stop()
 
 	log.Println("[ORCHESTRATOR] LegiTrack web scraper stopped successfully")
 }
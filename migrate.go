@@ -0,0 +1,356 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// migrationFiles holds the schema migrations, named <version>_<name>.sql
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// Migration is a schema change, applied once and in order of Version
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+func (m Migration) String() string {
+	return fmt.Sprintf("%04d_%s", m.Version, m.Name)
+}
+
+// MigrationStatus is a migration and when it was applied, zero if pending.
+// Unknown marks a migration recorded in the database but not in this build.
+type MigrationStatus struct {
+	Migration
+	AppliedAt time.Time
+	Unknown   bool
+}
+
+// Migrator applies the embedded migrations to a database, recording each
+// in the schema_migrations table
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+}
+
+// NewMigrator creates a migrator for db with the migrations of this build
+func NewMigrator(db *sql.DB) (*Migrator, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+// loadMigrations reads the embedded migrations, ordered by version
+func loadMigrations() ([]Migration, error) {
+	names, err := fs.Glob(migrationFiles, "migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	var migrations []Migration
+	seen := make(map[int]string)
+	for _, path := range names {
+		file := strings.TrimSuffix(strings.TrimPrefix(path, "migrations/"), ".sql")
+		prefix, name, ok := strings.Cut(file, "_")
+		version, err := strconv.Atoi(prefix)
+		if !ok || err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration %s is not named <version>_<name>.sql", path)
+		}
+		if other, dup := seen[version]; dup {
+			return nil, fmt.Errorf("migrations %s and %s share version %d", other, path, version)
+		}
+		seen[version] = path
+
+		body, err := migrationFiles.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, Migration{Version: version, Name: name, SQL: string(body)})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Status lists every migration of this build with when it was applied,
+// followed by any applied migration this build does not know
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var status []MigrationStatus
+	known := make(map[int]bool)
+	for _, mig := range m.migrations {
+		known[mig.Version] = true
+		status = append(status, MigrationStatus{Migration: mig, AppliedAt: applied[mig.Version].AppliedAt})
+	}
+
+	var unknown []MigrationStatus
+	for version, s := range applied {
+		if !known[version] {
+			s.Unknown = true
+			unknown = append(unknown, s)
+		}
+	}
+	sort.Slice(unknown, func(i, j int) bool { return unknown[i].Version < unknown[j].Version })
+	return append(status, unknown...), nil
+}
+
+// Pending returns the migrations not yet applied, in the order they would run
+func (m *Migrator) Pending(ctx context.Context) ([]Migration, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []Migration
+	for _, mig := range m.migrations {
+		if _, ok := applied[mig.Version]; !ok {
+			pending = append(pending, mig)
+		}
+	}
+	return pending, nil
+}
+
+// Legacy reports whether the database was created before migrations were
+// versioned: it has tables but no recorded baseline. Such databases are
+// upgraded in place and then recorded at the baseline.
+func (m *Migrator) Legacy(ctx context.Context) (bool, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return false, err
+	}
+	if _, ok := applied[1]; ok {
+		return false, nil
+	}
+	return tableExists(ctx, m.db, "updates")
+}
+
+// Migrate applies the pending migrations, each in its own transaction, and
+// returns those it applied. It stops at the first failure, leaving the
+// database at the last migration that succeeded.
+func (m *Migrator) Migrate(ctx context.Context) ([]Migration, error) {
+	legacy, err := m.Legacy(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := m.db.ExecContext(ctx, `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TIMESTAMP NOT NULL
+	)`); err != nil {
+		return nil, fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+
+	status, err := m.Status(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range status {
+		if s.Unknown {
+			log.Printf("[STORAGE] Database has migration %s, which this build does not know; it may be newer", s.Migration)
+		}
+	}
+
+	pending, err := m.Pending(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var applied []Migration
+	for _, mig := range pending {
+		if err := m.apply(ctx, mig, legacy && mig.Version == 1); err != nil {
+			return applied, fmt.Errorf("migration %s: %w", mig, err)
+		}
+		applied = append(applied, mig)
+	}
+	return applied, nil
+}
+
+// apply runs a migration and records it in one transaction. adopt first
+// upgrades a legacy database to the baseline's tables.
+func (m *Migrator) apply(ctx context.Context, mig Migration, adopt bool) error {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if adopt {
+		if err := upgradeLegacySchema(ctx, tx); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, mig.SQL); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)`,
+		mig.Version, mig.Name, time.Now().UTC().Format(time.RFC3339),
+	); err != nil {
+		return fmt.Errorf("failed to record migration: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+// applied returns the recorded migrations by version. A database without a
+// schema_migrations table has none.
+func (m *Migrator) applied(ctx context.Context) (map[int]MigrationStatus, error) {
+	exists, err := tableExists(ctx, m.db, "schema_migrations")
+	if err != nil || !exists {
+		return map[int]MigrationStatus{}, err
+	}
+
+	rows, err := m.db.QueryContext(ctx, `SELECT version, name, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query schema_migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int]MigrationStatus)
+	for rows.Next() {
+		var s MigrationStatus
+		var appliedAt string
+		if err := rows.Scan(&s.Version, &s.Name, &appliedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan migration: %w", err)
+		}
+		if s.AppliedAt, err = time.Parse(time.RFC3339, appliedAt); err != nil {
+			return nil, fmt.Errorf("failed to parse applied_at: %w", err)
+		}
+		applied[s.Version] = s
+	}
+	return applied, rows.Err()
+}
+
+// queryer is the part of *sql.DB and *sql.Tx used to inspect the schema
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// tableExists reports whether a table exists
+func tableExists(ctx context.Context, q queryer, table string) (bool, error) {
+	var n int
+	err := q.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?`, table,
+	).Scan(&n)
+	if err != nil {
+		return false, fmt.Errorf("failed to inspect schema: %w", err)
+	}
+	return n > 0, nil
+}
+
+// legacyColumns were added to tables before migrations were versioned, so
+// databases created by earlier builds may lack them
+var legacyColumns = []struct{ table, column, decl string }{
+	{"updates", "title", "TEXT"},
+	{"updates", "summary", "TEXT"},
+	{"updates", "content_type", "TEXT"},
+	{"updates", "body_hash", "TEXT"},
+	{"updates", "text_hash", "TEXT"},
+	{"updates", "blocked_by_robots", "BOOLEAN NOT NULL DEFAULT 0"},
+	{"updates", "reverted", "BOOLEAN NOT NULL DEFAULT 0"},
+	{"source_state", "skipped_runs", "INTEGER NOT NULL DEFAULT 0"},
+	{"source_state", "last_skipped_at", "TIMESTAMP"},
+}
+
+// upgradeLegacySchema brings the tables of a database created before
+// migrations were versioned up to the baseline, which then creates any
+// table still missing
+func upgradeLegacySchema(ctx context.Context, tx *sql.Tx) error {
+	for _, c := range legacyColumns {
+		columns, err := tableColumns(ctx, tx, c.table)
+		if err != nil {
+			return err
+		}
+		// Tables that do not exist yet are created by the baseline
+		if len(columns) == 0 || columns[c.column] {
+			continue
+		}
+		stmt := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", c.table, c.column, c.decl)
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("failed to add %s.%s: %w", c.table, c.column, err)
+		}
+	}
+	return dropUniqueHash(ctx, tx)
+}
+
+// uniqueHash matches the hash column as declared when content was
+// deduplicated across all sources
+var uniqueHash = regexp.MustCompile(`(?i)\bhash\s+TEXT\s+UNIQUE\b`)
+
+// dropUniqueHash rebuilds an updates table created with a UNIQUE hash. SQLite
+// cannot drop a constraint, so the rows are copied into a table declared the
+// same way without it; the baseline then recreates the indexes.
+func dropUniqueHash(ctx context.Context, tx *sql.Tx) error {
+	var ddl string
+	err := tx.QueryRowContext(ctx,
+		`SELECT sql FROM sqlite_master WHERE type = 'table' AND name = 'updates'`,
+	).Scan(&ddl)
+	if err != nil {
+		return fmt.Errorf("failed to inspect updates: %w", err)
+	}
+	if !uniqueHash.MatchString(ddl) {
+		return nil
+	}
+
+	rebuilt := uniqueHash.ReplaceAllString(ddl, "hash TEXT")
+	rebuilt = strings.Replace(rebuilt, "updates", "updates_rebuild", 1)
+
+	statements := []string{
+		rebuilt,
+		`INSERT INTO updates_rebuild SELECT * FROM updates`,
+		`DROP TABLE updates`,
+		`ALTER TABLE updates_rebuild RENAME TO updates`,
+	}
+	for _, stmt := range statements {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("failed to rebuild updates: %w", err)
+		}
+	}
+
+	log.Printf("[STORAGE] Rebuilt updates table for per-source deduplication")
+	return nil
+}
+
+// tableColumns returns the columns of a table, none if it does not exist
+func tableColumns(ctx context.Context, q queryer, table string) (map[string]bool, error) {
+	rows, err := q.QueryContext(ctx, fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	columns := make(map[string]bool)
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk); err != nil {
+			return nil, fmt.Errorf("failed to inspect %s: %w", table, err)
+		}
+		columns[name] = true
+	}
+	return columns, rows.Err()
+}
@@ -0,0 +1,111 @@
+-- Baseline schema. Databases created before migrations were versioned are
+-- brought up to it by upgradeLegacySchema and then recorded at this version.
+
+CREATE TABLE IF NOT EXISTS updates (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    source_id TEXT NOT NULL,
+    url TEXT NOT NULL,
+    fetched_at TIMESTAMP NOT NULL,
+    hash TEXT,
+    status_code INTEGER NOT NULL,
+    success BOOLEAN NOT NULL,
+    retry_count INTEGER NOT NULL,
+    error_detail TEXT,
+    body_size INTEGER DEFAULT 0,
+    title TEXT,
+    summary TEXT,
+    content_type TEXT,
+    body_hash TEXT,
+    text_hash TEXT,
+    blocked_by_robots BOOLEAN NOT NULL DEFAULT 0,
+    reverted BOOLEAN NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_updates_source_id ON updates(source_id);
+CREATE INDEX IF NOT EXISTS idx_updates_source_url ON updates(source_id, url, fetched_at);
+CREATE INDEX IF NOT EXISTS idx_updates_hash ON updates(hash);
+CREATE INDEX IF NOT EXISTS idx_updates_fetched_at ON updates(fetched_at);
+CREATE INDEX IF NOT EXISTS idx_updates_date ON updates(date(fetched_at));
+
+CREATE TABLE IF NOT EXISTS source_state (
+    source_id TEXT PRIMARY KEY,
+    etag TEXT,
+    last_modified TEXT,
+    last_checked_at TIMESTAMP,
+    last_changed_at TIMESTAMP,
+    last_status_code INTEGER,
+    skipped_runs INTEGER NOT NULL DEFAULT 0,
+    last_skipped_at TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS scrape_runs (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    source_id TEXT NOT NULL,
+    url TEXT NOT NULL,
+    started_at TIMESTAMP NOT NULL,
+    finished_at TIMESTAMP NOT NULL,
+    duration_ms INTEGER NOT NULL,
+    status_code INTEGER,
+    bytes INTEGER NOT NULL DEFAULT 0,
+    outcome TEXT NOT NULL,
+    attempts INTEGER NOT NULL,
+    error_detail TEXT
+);
+
+CREATE INDEX IF NOT EXISTS idx_scrape_runs_source ON scrape_runs(source_id, started_at);
+CREATE INDEX IF NOT EXISTS idx_scrape_runs_date ON scrape_runs(date(started_at));
+
+CREATE TABLE IF NOT EXISTS items (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    source_id TEXT NOT NULL,
+    item_id TEXT NOT NULL,
+    title TEXT,
+    link TEXT,
+    reference TEXT,
+    date_text TEXT,
+    published_at TIMESTAMP,
+    first_seen_at TIMESTAMP NOT NULL,
+    last_seen_at TIMESTAMP NOT NULL,
+    UNIQUE(source_id, item_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_items_first_seen ON items(date(first_seen_at));
+
+CREATE TABLE IF NOT EXISTS documents (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    source_id TEXT NOT NULL,
+    url TEXT NOT NULL,
+    page_url TEXT NOT NULL,
+    fetched_at TIMESTAMP NOT NULL,
+    content_type TEXT,
+    title TEXT,
+    pages INTEGER DEFAULT 0,
+    body_size INTEGER DEFAULT 0,
+    body_hash TEXT NOT NULL,
+    text_hash TEXT,
+    UNIQUE(source_id, url, body_hash)
+);
+
+CREATE INDEX IF NOT EXISTS idx_documents_source_url ON documents(source_id, url);
+
+CREATE TABLE IF NOT EXISTS sitemap_urls (
+    source_id TEXT NOT NULL,
+    loc TEXT NOT NULL,
+    lastmod TIMESTAMP,
+    first_seen_at TIMESTAMP NOT NULL,
+    last_seen_at TIMESTAMP NOT NULL,
+    fetched_at TIMESTAMP,
+    fetched_lastmod TIMESTAMP,
+    PRIMARY KEY(source_id, loc)
+);
+
+CREATE TABLE IF NOT EXISTS crawl_frontier (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    source_id TEXT NOT NULL,
+    url TEXT NOT NULL,
+    depth INTEGER NOT NULL,
+    queued_at TIMESTAMP NOT NULL,
+    visited_at TIMESTAMP,
+    UNIQUE(source_id, url)
+);
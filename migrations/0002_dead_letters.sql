@@ -0,0 +1,17 @@
+-- Notifications a channel gave up delivering, kept for inspection and replay.
+
+CREATE TABLE IF NOT EXISTS dead_letters (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    channel TEXT NOT NULL,
+    event_id TEXT NOT NULL,
+    event TEXT NOT NULL,
+    source_id TEXT NOT NULL,
+    target TEXT,
+    payload TEXT NOT NULL,
+    attempts INTEGER NOT NULL,
+    last_status_code INTEGER,
+    error_detail TEXT,
+    failed_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_dead_letters_failed_at ON dead_letters(failed_at);
@@ -0,0 +1,12 @@
+-- How far each recipient's hourly or daily digest has got. A digest covers
+-- the updates stored after the previous one's last_update_id, in the order
+-- they were saved, however late a fetch was saved.
+
+CREATE TABLE IF NOT EXISTS digest_state (
+    recipient TEXT NOT NULL,
+    mode TEXT NOT NULL,
+    last_update_id INTEGER NOT NULL,
+    covered_at TIMESTAMP NOT NULL,
+    last_sent_at TIMESTAMP,
+    PRIMARY KEY(recipient, mode)
+);
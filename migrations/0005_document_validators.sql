@@ -0,0 +1,7 @@
+-- HTTP validators and the time each stored attachment version was last
+-- checked, so known attachment URLs are re-fetched conditionally and a file
+-- replaced in place is recorded as a new version under its body_hash.
+
+ALTER TABLE documents ADD COLUMN etag TEXT;
+ALTER TABLE documents ADD COLUMN last_modified TEXT;
+ALTER TABLE documents ADD COLUMN checked_at TIMESTAMP;
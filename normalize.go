@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// NormalizeConfig describes the noise removed from a source's pages before
+// hashing, so visitor counters, CSRF tokens and rotating banners do not
+// register as changes
+type NormalizeConfig struct {
+	Include            []string `yaml:"include"` // CSS, or XPath with an "xpath:" prefix
+	Exclude            []string `yaml:"exclude"`
+	Scrub              []string `yaml:"scrub"` // regular expressions removed from the result
+	StripAttributes    bool     `yaml:"strip_attributes"`
+	KeepAttributes     []string `yaml:"keep_attributes"`
+	CollapseWhitespace bool     `yaml:"collapse_whitespace"`
+}
+
+// empty reports whether the configuration asks for any normalization
+func (c NormalizeConfig) empty() bool {
+	return len(c.Include) == 0 && len(c.Exclude) == 0 && len(c.Scrub) == 0 &&
+		!c.StripAttributes && !c.CollapseWhitespace
+}
+
+// Normalizer applies a source's normalization rules to fetched bodies
+type Normalizer struct {
+	include            []nodeSelector
+	exclude            []nodeSelector
+	scrub              []*regexp.Regexp
+	stripAttributes    bool
+	keepAttributes     map[string]bool
+	collapseWhitespace bool
+}
+
+// NewNormalizer compiles normalization rules. It returns nil when no rules are configured.
+func NewNormalizer(cfg NormalizeConfig) (*Normalizer, error) {
+	if cfg.empty() {
+		return nil, nil
+	}
+
+	include, err := compileSelectors(cfg.Include)
+	if err != nil {
+		return nil, err
+	}
+	exclude, err := compileSelectors(cfg.Exclude)
+	if err != nil {
+		return nil, err
+	}
+
+	n := &Normalizer{
+		include:            include,
+		exclude:            exclude,
+		stripAttributes:    cfg.StripAttributes,
+		keepAttributes:     make(map[string]bool),
+		collapseWhitespace: cfg.CollapseWhitespace,
+	}
+
+	for _, pattern := range cfg.Scrub {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid scrub pattern %q: %w", pattern, err)
+		}
+		n.scrub = append(n.scrub, re)
+	}
+
+	for _, attr := range cfg.KeepAttributes {
+		n.keepAttributes[strings.ToLower(attr)] = true
+	}
+
+	return n, nil
+}
+
+// Normalize returns the part of body that matters for change detection.
+// Selector and attribute rules only apply to HTML; scrubbing and whitespace
+// collapsing apply to any body.
+func (n *Normalizer) Normalize(body []byte) ([]byte, error) {
+	out := string(body)
+
+	if isHTML(body) && (len(n.include) > 0 || len(n.exclude) > 0 || n.stripAttributes) {
+		selected, err := n.selectHTML(body)
+		if err != nil {
+			return nil, err
+		}
+		out = selected
+	}
+
+	for _, re := range n.scrub {
+		out = re.ReplaceAllString(out, "")
+	}
+
+	if n.collapseWhitespace {
+		out = strings.Join(strings.Fields(out), " ")
+	}
+
+	return []byte(out), nil
+}
+
+// selectHTML applies the exclude, include and attribute rules and
+// serializes what remains
+func (n *Normalizer) selectHTML(body []byte) (string, error) {
+	doc, err := html.Parse(bytes.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("failed to parse HTML: %w", err)
+	}
+
+	for _, sel := range n.exclude {
+		for _, node := range sel.Select(doc) {
+			if node.Parent != nil {
+				node.Parent.RemoveChild(node)
+			}
+		}
+	}
+
+	roots := []*html.Node{doc}
+	if len(n.include) > 0 {
+		roots = nil
+		for _, sel := range n.include {
+			roots = append(roots, sel.Select(doc)...)
+		}
+		roots = outermostNodes(roots)
+
+		// An include that matches nothing usually means the page layout
+		// changed; hashing an empty selection would hide every later change
+		if len(roots) == 0 {
+			return "", fmt.Errorf("include selectors matched nothing")
+		}
+	}
+
+	var buf bytes.Buffer
+	for _, root := range roots {
+		if n.stripAttributes {
+			n.stripNodeAttributes(root)
+		}
+		if err := html.Render(&buf, root); err != nil {
+			return "", fmt.Errorf("failed to render HTML: %w", err)
+		}
+		buf.WriteByte('\n')
+	}
+
+	return buf.String(), nil
+}
+
+// stripNodeAttributes removes all attributes not explicitly kept from root and its descendants
+func (n *Normalizer) stripNodeAttributes(root *html.Node) {
+	if root.Type == html.ElementNode {
+		kept := root.Attr[:0]
+		for _, attr := range root.Attr {
+			if n.keepAttributes[attr.Key] {
+				kept = append(kept, attr)
+			}
+		}
+		root.Attr = kept
+	}
+	for c := root.FirstChild; c != nil; c = c.NextSibling {
+		n.stripNodeAttributes(c)
+	}
+}
+
+// outermostNodes drops duplicates and nodes nested inside another node in
+// the list, so overlapping includes are not counted twice
+func outermostNodes(nodes []*html.Node) []*html.Node {
+	selected := make(map[*html.Node]bool, len(nodes))
+	for _, node := range nodes {
+		selected[node] = true
+	}
+
+	var result []*html.Node
+	seen := make(map[*html.Node]bool, len(nodes))
+	for _, node := range nodes {
+		if seen[node] {
+			continue
+		}
+		seen[node] = true
+
+		nested := false
+		for p := node.Parent; p != nil; p = p.Parent {
+			if selected[p] {
+				nested = true
+				break
+			}
+		}
+		if !nested {
+			result = append(result, node)
+		}
+	}
+	return result
+}
+
+// contentHashes returns the change-detection hash of body, computed over the
+// normalized content when src has normalization rules, and the raw SHA-256
+func contentHashes(src Source, body []byte) (hash, rawHash string) {
+	rawSum := sha256.Sum256(body)
+	rawHash = hex.EncodeToString(rawSum[:])
+
+	if src.Normalizer == nil {
+		return rawHash, rawHash
+	}
+
+	normalized, err := src.Normalizer.Normalize(body)
+	if err != nil {
+		log.Printf("[NORMALIZE] %s: %v; falling back to raw hash", src.ID, err)
+		return rawHash, rawHash
+	}
+
+	sum := sha256.Sum256(normalized)
+	return hex.EncodeToString(sum[:]), rawHash
+}
@@ -0,0 +1,396 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultNotifyMaxRetries bounds the retries of a delivery before it is
+	// given up on and dead-lettered
+	defaultNotifyMaxRetries = 5
+
+	// outboxPollInterval is how often the outbox looks for deliveries due
+	// for a retry or requeued by `notify retry`
+	outboxPollInterval = 30 * time.Second
+
+	// outboxBatchSize bounds the entries loaded at a time
+	outboxBatchSize = 50
+)
+
+// Notification events
+const (
+	EventUpdate       = "update.created" // a new version of a page was stored
+	EventSourceFailed = "source.failed"  // a check of a source failed
+)
+
+// Notification tells subscribers about a newly stored version of a source,
+// or about a failed check of one
+type Notification struct {
+	Event string
+	Update
+	SourceName string
+	Category   string
+	Severity   string
+	Change     *ChangeExcerpt // nil for a first version or when no diff is available
+
+	// ChangedText holds every changed line, for routing on keywords; only
+	// the excerpt in Change is kept for delivery
+	ChangedText string `json:"-"`
+}
+
+// Headline describes the change in a few words
+func (n Notification) Headline() string {
+	if n.Event == EventSourceFailed {
+		return "Check failed: " + n.SourceName
+	}
+	if n.Reverted {
+		return "Reverted to an earlier version: " + n.SourceName
+	}
+	return "New content: " + n.SourceName
+}
+
+// Subject is the subject line of a notification email
+func (n Notification) Subject() string {
+	subject := "[LegiTrack] " + n.Headline()
+	if n.Title != "" {
+		subject += " - " + n.Title
+	}
+	return subject
+}
+
+// Key identifies the event, the same for every delivery of it, so receivers
+// can drop the duplicates at-least-once delivery may cause
+func (n Notification) Key() string {
+	at := strconv.FormatInt(n.FetchedAt.UnixMilli(), 10)
+	if n.Event == EventSourceFailed {
+		return n.SourceID + ".failed." + at
+	}
+	return n.SourceID + "." + shortHash(n.Hash) + "." + at
+}
+
+// Notifier delivers notifications over one channel
+type Notifier interface {
+	Name() string
+	Target() string // where notifications go, for logs and dead letters
+	Handles(n Notification) bool
+	Notify(ctx context.Context, n Notification) error
+}
+
+// newNotifiers creates a notifier for each enabled channel and routed
+// destination, logging and skipping those that are misconfigured. The
+// default recipients may be left empty when routes have their own, and
+// recipients of digests are left to the Digester.
+func newNotifiers(cfg NotificationConfig, routes []configuredRoute) []Notifier {
+	var notifiers []Notifier
+	instant := deliveryRecipients(cfg, cfg.Email.Recipients, DeliveryInstant)
+	if cfg.Email.Enabled && len(instant) > 0 {
+		emailCfg := cfg.Email
+		emailCfg.Recipients = instant
+		email, err := NewEmailNotifier(emailCfg)
+		if err != nil {
+			log.Printf("[NOTIFY] Email notifications disabled: %v", err)
+		} else {
+			notifiers = append(notifiers, email)
+		}
+	}
+	if cfg.Webhook.Enabled {
+		webhook, err := NewWebhookNotifier(cfg.Webhook)
+		if err != nil {
+			log.Printf("[NOTIFY] Webhook notifications disabled: %v", err)
+		} else {
+			notifiers = append(notifiers, webhook)
+		}
+	}
+	return append(notifiers, newRoutedNotifiers(cfg, routes)...)
+}
+
+// newNotification describes a stored update for notifiers, with what
+// changed since the previous version of its page
+func newNotification(ctx context.Context, storage Storage, src Source, update Update) Notification {
+	n := baseNotification(EventUpdate, src, update)
+
+	diff, err := changeDiff(ctx, storage, src.Normalizer, update)
+	if err != nil {
+		log.Printf("[NOTIFY] %v", err)
+	}
+	if diff != nil {
+		n.Change = diff.Excerpt(maxExcerptBlocks)
+		var lines []string
+		for _, block := range diff.Blocks {
+			lines = append(lines, block.Removed...)
+			lines = append(lines, block.Added...)
+		}
+		n.ChangedText = strings.Join(lines, "\n")
+	}
+	return n
+}
+
+// newFailureNotification describes a failed check of a source
+func newFailureNotification(src Source, update Update) Notification {
+	return baseNotification(EventSourceFailed, src, update)
+}
+
+// baseNotification fills in the event and what is known about its source
+func baseNotification(event string, src Source, update Update) Notification {
+	n := Notification{Event: event, Update: update, SourceName: src.Name, Category: src.Category, Severity: src.Severity}
+	if n.SourceName == "" {
+		n.SourceName = update.SourceID
+	}
+	if n.Severity == "" {
+		n.Severity = SeverityMedium
+	}
+	return n
+}
+
+// Outbox delivers the notifications queued with each update, at least once.
+// Failed deliveries are retried with backoff until the retries run out, when
+// the entry is marked dead and kept as a dead letter.
+type Outbox struct {
+	storage    Storage
+	notifiers  map[string]Notifier
+	channels   []string
+	maxRetries int
+	backoff    Backoff
+	wake       chan struct{}
+	stop       chan struct{}
+	wg         sync.WaitGroup
+}
+
+// NewOutbox creates an outbox delivering to notifiers. A maxRetries of zero
+// or less uses the default.
+func NewOutbox(storage Storage, notifiers []Notifier, maxRetries int) *Outbox {
+	if maxRetries <= 0 {
+		maxRetries = defaultNotifyMaxRetries
+	}
+
+	o := &Outbox{
+		storage:    storage,
+		notifiers:  make(map[string]Notifier),
+		maxRetries: maxRetries,
+		backoff: Backoff{
+			Base:          30 * time.Second,
+			Max:           30 * time.Minute,
+			MaxRetryAfter: time.Hour,
+		},
+		wake: make(chan struct{}, 1),
+		stop: make(chan struct{}),
+	}
+	for _, notifier := range notifiers {
+		o.notifiers[notifier.Name()] = notifier
+		o.channels = append(o.channels, notifier.Name())
+	}
+	return o
+}
+
+// Enabled reports whether any channel is configured
+func (o *Outbox) Enabled() bool {
+	return len(o.notifiers) > 0
+}
+
+// Entries returns the outbox entries announcing n, one for each channel
+// that handles its event, for saving with the update
+func (o *Outbox) Entries(n Notification) ([]OutboxEntry, error) {
+	// The content itself is stored with the update; the notification only
+	// needs what it shows
+	stored := n
+	stored.Body = nil
+	stored.Text = ""
+	stored.Items = nil
+	stored.Documents = nil
+
+	payload, err := json.Marshal(stored)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode notification %s: %w", n.Key(), err)
+	}
+
+	var entries []OutboxEntry
+	now := time.Now().UTC()
+	for _, channel := range o.channels {
+		if !o.notifiers[channel].Handles(n) {
+			continue
+		}
+		entries = append(entries, OutboxEntry{
+			EventID:   n.Key(),
+			Event:     n.Event,
+			Channel:   channel,
+			SourceID:  n.SourceID,
+			Payload:   payload,
+			CreatedAt: now,
+		})
+	}
+	return entries, nil
+}
+
+// Wake asks for entries just saved to be delivered without waiting for the
+// next poll
+func (o *Outbox) Wake() {
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Start delivers due entries, starting with any left by an earlier run,
+// until ctx is done or Stop is called
+func (o *Outbox) Start(ctx context.Context) {
+	if !o.Enabled() {
+		return
+	}
+
+	o.wg.Add(1)
+	go func() {
+		defer o.wg.Done()
+		ticker := time.NewTicker(outboxPollInterval)
+		defer ticker.Stop()
+
+		for {
+			o.drain(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-o.stop:
+				return
+			case <-o.wake:
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop waits for the delivery in progress, if any. Entries not yet
+// delivered stay in the outbox for the next run.
+func (o *Outbox) Stop() {
+	close(o.stop)
+	o.wg.Wait()
+}
+
+// stopping reports whether delivery should stop
+func (o *Outbox) stopping(ctx context.Context) bool {
+	select {
+	case <-o.stop:
+		return true
+	default:
+		return ctx.Err() != nil
+	}
+}
+
+// drain delivers every entry that is due
+func (o *Outbox) drain(ctx context.Context) {
+	for !o.stopping(ctx) {
+		entries, err := o.storage.DueOutboxEntries(ctx, o.channels, time.Now(), outboxBatchSize)
+		if err != nil {
+			if !o.stopping(ctx) {
+				log.Printf("[NOTIFY] Failed to load outbox: %v", err)
+			}
+			return
+		}
+
+		for _, entry := range entries {
+			if o.stopping(ctx) {
+				return
+			}
+			o.deliver(ctx, entry)
+		}
+		if len(entries) < outboxBatchSize {
+			return
+		}
+	}
+}
+
+// deliver makes one attempt at an entry and records how it went. A delivery
+// under way finishes even when shutdown begins, bounded by the notifier's
+// own timeout, so it is not counted as a failure.
+func (o *Outbox) deliver(ctx context.Context, entry OutboxEntry) {
+	ctx = context.WithoutCancel(ctx)
+	notifier := o.notifiers[entry.Channel]
+
+	var n Notification
+	if err := json.Unmarshal(entry.Payload, &n); err != nil {
+		o.fail(ctx, entry, notifier, fmt.Errorf("undecodable notification: %w", err), false)
+		return
+	}
+
+	if err := notifier.Notify(ctx, n); err != nil {
+		o.fail(ctx, entry, notifier, err, retryableDelivery(err))
+		return
+	}
+
+	if err := o.storage.MarkOutboxSent(ctx, entry.ID, time.Now()); err != nil {
+		// Delivered but not recorded: it will be sent again, with the same key
+		log.Printf("[NOTIFY] %v", err)
+	}
+	log.Printf("[NOTIFY] Sent %s notification %s", entry.Channel, entry.EventID)
+}
+
+// retryableDelivery reports whether a failed delivery may succeed later.
+// Webhook failures are classified as fetchErrors; an SMTP server rejects a
+// message for good with a 5xx reply, such as for an unknown recipient.
+func retryableDelivery(err error) bool {
+	var fe *fetchError
+	if errors.As(err, &fe) {
+		return fe.Retryable
+	}
+	var reply *textproto.Error
+	if errors.As(err, &reply) {
+		return reply.Code < 500
+	}
+	return true
+}
+
+// fail records a failed attempt, scheduling a retry with backoff or, once
+// the retries are used up or the failure is permanent, dead-lettering it
+func (o *Outbox) fail(ctx context.Context, entry OutboxEntry, notifier Notifier, cause error, retryable bool) {
+	attempt := entry.Attempts + 1
+	dead := !retryable || attempt > o.maxRetries
+
+	delay := o.backoff.Delay(attempt)
+	var fe *fetchError
+	if errors.As(cause, &fe) && fe.RetryAfter > delay {
+		delay = min(fe.RetryAfter, o.backoff.MaxRetryAfter)
+	}
+	now := time.Now().UTC()
+
+	if err := o.storage.RecordOutboxFailure(ctx, entry.ID, cause.Error(), now.Add(delay), dead); err != nil {
+		log.Printf("[NOTIFY] %v", err)
+	}
+
+	if !dead {
+		log.Printf("[NOTIFY] %s notification %s failed (attempt %d/%d, retrying in %s): %v",
+			entry.Channel, entry.EventID, attempt, o.maxRetries+1, delay.Round(time.Second), cause)
+		return
+	}
+
+	log.Printf("[NOTIFY] Giving up on %s notification %s after %d attempts: %v",
+		entry.Channel, entry.EventID, attempt, cause)
+
+	letter := DeadLetter{
+		Channel:  entry.Channel,
+		EventID:  entry.EventID,
+		Event:    entry.Event,
+		SourceID: entry.SourceID,
+		Target:   notifier.Target(),
+		Payload:  entry.Payload,
+		Attempts: attempt,
+		Error:    cause.Error(),
+		FailedAt: now,
+	}
+	var reply *textproto.Error
+	switch {
+	case fe != nil:
+		letter.StatusCode = fe.StatusCode
+	case errors.As(cause, &reply):
+		letter.StatusCode = reply.Code
+	}
+	if err := o.storage.SaveDeadLetter(ctx, letter); err != nil {
+		log.Printf("[NOTIFY] %v", err)
+	}
+}
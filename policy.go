@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+)
+
+// defaultMaxRedirects matches net/http's own redirect limit
+const defaultMaxRedirects = 10
+
+// redirectLimitKey carries a source's redirect limit on the request context
+type redirectLimitKey struct{}
+
+// withRedirectLimit attaches a source's redirect limit to ctx
+func withRedirectLimit(ctx context.Context, src Source) context.Context {
+	limit := src.MaxRedirects
+	if limit == 0 {
+		limit = defaultMaxRedirects
+	}
+	return context.WithValue(ctx, redirectLimitKey{}, limit)
+}
+
+// checkRedirect enforces the redirect limit carried on the request context.
+// A negative limit disables redirects so the 3xx itself is judged by the
+// source's accepted status codes.
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	limit, ok := req.Context().Value(redirectLimitKey{}).(int)
+	if !ok {
+		limit = defaultMaxRedirects
+	}
+
+	if limit < 0 {
+		return http.ErrUseLastResponse
+	}
+	if len(via) > limit {
+		return fmt.Errorf("stopped after %d redirects", limit)
+	}
+	return nil
+}
+
+// statusAccepted reports whether a response status counts as a successful
+// fetch for src. Without an explicit list any 2xx is accepted.
+func statusAccepted(src Source, code int) bool {
+	if len(src.AcceptedStatus) == 0 {
+		return code >= 200 && code < 300
+	}
+
+	for _, accepted := range src.AcceptedStatus {
+		if code == accepted {
+			return true
+		}
+	}
+	return false
+}
+
+// matchSoft404 returns the first soft-404 pattern matching body, if any.
+// Soft 404s are error pages served with a success status.
+func matchSoft404(src Source, body []byte) *regexp.Regexp {
+	for _, pattern := range src.Soft404Patterns {
+		if pattern.Match(body) {
+			return pattern
+		}
+	}
+	return nil
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/chromedp"
+)
+
+// Renderer produces the DOM of a JavaScript-rendered page as HTML
+type Renderer interface {
+	Render(ctx context.Context, req RenderRequest) (*RenderResult, error)
+}
+
+// RenderRequest describes a page to render
+type RenderRequest struct {
+	URL             string
+	UserAgent       string
+	WaitForSelector string
+	WaitNetworkIdle bool
+}
+
+// RenderResult holds a rendered page. StatusCode is zero when the backend
+// cannot observe the main document's HTTP status.
+type RenderResult struct {
+	HTML       []byte
+	StatusCode int
+}
+
+// NewRenderer creates the renderer backend selected in the configuration
+func NewRenderer(cfg RendererConfig) (Renderer, error) {
+	switch cfg.Backend {
+	case "", "chrome":
+		renderer := NewChromeRenderer(cfg.ChromePath)
+		if cfg.NetworkIdleQuiet != "" {
+			quiet, err := time.ParseDuration(cfg.NetworkIdleQuiet)
+			if err != nil {
+				return nil, fmt.Errorf("invalid network_idle_quiet: %w", err)
+			}
+			renderer.idleQuiet = quiet
+		}
+		return renderer, nil
+	case "command":
+		if len(cfg.Command) == 0 {
+			return nil, fmt.Errorf("renderer backend %q requires a command", cfg.Backend)
+		}
+		return NewCommandRenderer(cfg.Command), nil
+	default:
+		return nil, fmt.Errorf("unknown renderer backend %q", cfg.Backend)
+	}
+}
+
+// ChromeRenderer renders pages in headless Chrome over the DevTools protocol
+type ChromeRenderer struct {
+	execPath  string
+	idleQuiet time.Duration
+}
+
+// NewChromeRenderer creates a Chrome renderer. An empty execPath lets
+// chromedp locate a Chrome or Chromium install.
+func NewChromeRenderer(execPath string) *ChromeRenderer {
+	return &ChromeRenderer{
+		execPath:  execPath,
+		idleQuiet: 500 * time.Millisecond,
+	}
+}
+
+// Render loads the page and returns its serialized DOM
+func (c *ChromeRenderer) Render(ctx context.Context, req RenderRequest) (*RenderResult, error) {
+	opts := append(chromedp.DefaultExecAllocatorOptions[:], chromedp.UserAgent(req.UserAgent))
+	if c.execPath != "" {
+		opts = append(opts, chromedp.ExecPath(c.execPath))
+	}
+
+	// A fresh browser per render keeps a crashed or wedged tab from
+	// poisoning the next retry
+	allocCtx, cancelAlloc := chromedp.NewExecAllocator(ctx, opts...)
+	defer cancelAlloc()
+	tabCtx, cancelTab := chromedp.NewContext(allocCtx)
+	defer cancelTab()
+
+	var tracker *networkTracker
+	if req.WaitNetworkIdle {
+		tracker = newNetworkTracker()
+		chromedp.ListenTarget(tabCtx, tracker.observe)
+	}
+
+	if err := chromedp.Run(tabCtx, network.Enable()); err != nil {
+		return nil, fmt.Errorf("failed to start browser: %w", err)
+	}
+
+	resp, err := chromedp.RunResponse(tabCtx, chromedp.Navigate(req.URL))
+	if err != nil {
+		return nil, fmt.Errorf("navigation failed: %w", err)
+	}
+
+	var actions []chromedp.Action
+	if tracker != nil {
+		actions = append(actions, tracker.waitIdle(c.idleQuiet))
+	}
+	if req.WaitForSelector != "" {
+		actions = append(actions, chromedp.WaitReady(req.WaitForSelector, chromedp.ByQuery))
+	}
+
+	var html string
+	actions = append(actions, chromedp.OuterHTML("html", &html, chromedp.ByQuery))
+	if err := chromedp.Run(tabCtx, actions...); err != nil {
+		return nil, fmt.Errorf("failed to capture page: %w", err)
+	}
+
+	result := &RenderResult{HTML: []byte(html)}
+	if resp != nil {
+		result.StatusCode = int(resp.Status)
+	}
+	return result, nil
+}
+
+// maxIdleInflight tolerates long-polling and analytics beacons that never finish
+const maxIdleInflight = 2
+
+// networkTracker follows in-flight requests of a tab to detect network idle
+type networkTracker struct {
+	mu       sync.Mutex
+	inflight map[network.RequestID]struct{}
+	lastSeen time.Time
+}
+
+func newNetworkTracker() *networkTracker {
+	return &networkTracker{
+		inflight: make(map[network.RequestID]struct{}),
+		lastSeen: time.Now(),
+	}
+}
+
+// observe is a chromedp target listener counting requests in and out
+func (t *networkTracker) observe(ev any) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	switch e := ev.(type) {
+	case *network.EventRequestWillBeSent:
+		t.inflight[e.RequestID] = struct{}{}
+	case *network.EventLoadingFinished:
+		delete(t.inflight, e.RequestID)
+	case *network.EventLoadingFailed:
+		delete(t.inflight, e.RequestID)
+	default:
+		return
+	}
+	t.lastSeen = time.Now()
+}
+
+// idle reports whether the network has been quiet for at least quiet
+func (t *networkTracker) idle(quiet time.Duration) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.inflight) <= maxIdleInflight && time.Since(t.lastSeen) >= quiet
+}
+
+// waitIdle returns an action that blocks until the network is idle
+func (t *networkTracker) waitIdle(quiet time.Duration) chromedp.ActionFunc {
+	return func(ctx context.Context) error {
+		ticker := time.NewTicker(100 * time.Millisecond)
+		defer ticker.Stop()
+
+		for !t.idle(quiet) {
+			select {
+			case <-ctx.Done():
+				return fmt.Errorf("waiting for network idle: %w", ctx.Err())
+			case <-ticker.C:
+			}
+		}
+		return nil
+	}
+}
+
+// CommandRenderer renders pages with an external program. The URL is written
+// to the program's stdin and the rendered HTML is read from its stdout; wait
+// options are passed as LEGITRACK_* environment variables.
+type CommandRenderer struct {
+	command []string
+}
+
+// NewCommandRenderer creates a renderer that runs command for each page
+func NewCommandRenderer(command []string) *CommandRenderer {
+	return &CommandRenderer{command: command}
+}
+
+// Render runs the render command and returns its output
+func (c *CommandRenderer) Render(ctx context.Context, req RenderRequest) (*RenderResult, error) {
+	cmd := exec.CommandContext(ctx, c.command[0], c.command[1:]...)
+	cmd.Stdin = strings.NewReader(req.URL + "\n")
+	cmd.Env = append(os.Environ(),
+		"LEGITRACK_URL="+req.URL,
+		"LEGITRACK_USER_AGENT="+req.UserAgent,
+		"LEGITRACK_WAIT_SELECTOR="+req.WaitForSelector,
+		"LEGITRACK_WAIT_NETWORK_IDLE="+strconv.FormatBool(req.WaitNetworkIdle),
+	)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("render command interrupted: %w", ctx.Err())
+		}
+		return nil, fmt.Errorf("render command failed: %w: %s", err, lastLine(stderr.String()))
+	}
+
+	if stdout.Len() == 0 {
+		return nil, fmt.Errorf("render command produced no output")
+	}
+
+	return &RenderResult{HTML: stdout.Bytes()}, nil
+}
+
+// lastLine returns the last non-empty line of s, which for most tools is the error
+func lastLine(s string) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
+
+// isRetryableRenderError reports whether a render failure may succeed on retry.
+// Missing browsers or scripts and cancellation are terminal; page-level
+// failures on flaky sites usually are not.
+func isRetryableRenderError(err error) bool {
+	return !errors.Is(err, exec.ErrNotFound) && !errors.Is(err, os.ErrNotExist) &&
+		!errors.Is(err, context.Canceled)
+}
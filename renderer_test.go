@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRenderer renders pages with a function, recording every request, so
+// the browser scraper can be tested without a browser installed
+type fakeRenderer struct {
+	mu       sync.Mutex
+	requests []RenderRequest
+	render   func(ctx context.Context, req RenderRequest, call int) (*RenderResult, error)
+}
+
+func (f *fakeRenderer) Render(ctx context.Context, req RenderRequest) (*RenderResult, error) {
+	f.mu.Lock()
+	f.requests = append(f.requests, req)
+	call := len(f.requests)
+	f.mu.Unlock()
+	return f.render(ctx, req, call)
+}
+
+func (f *fakeRenderer) calls() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.requests)
+}
+
+// newTestBrowserScraper returns a browser scraper rendering with renderer
+// and retrying without delay
+func newTestBrowserScraper(renderer Renderer) *BrowserScraper {
+	b := NewBrowserScraper()
+	b.SetRenderer(renderer)
+	b.SetUserAgent("LegiTrack-Test/1.0")
+	b.SetBackoff(Backoff{Base: time.Millisecond, Max: time.Millisecond})
+	return b
+}
+
+// renderedPage is a page whose results only exist once its script has run
+const renderedPage = `<html><head><title>Latest Judgments</title></head>
+<body><div id="results"><p>Judgment in Civil Appeal No. 1234 of 2026</p></div></body></html>`
+
+func TestBrowserScraperRendersWithFakeRenderer(t *testing.T) {
+	renderer := &fakeRenderer{render: func(ctx context.Context, req RenderRequest, call int) (*RenderResult, error) {
+		return &RenderResult{HTML: []byte(renderedPage), StatusCode: 200}, nil
+	}}
+	src := Source{
+		ID:              "court",
+		URL:             "https://court.example/judgments",
+		WaitForSelector: "#results",
+		WaitNetworkIdle: true,
+	}
+
+	out := make(chan Update, 1)
+	if err := newTestBrowserScraper(renderer).Scrape(context.Background(), src, out); err != nil {
+		t.Fatalf("Scrape: %v", err)
+	}
+	update := <-out
+
+	if !update.Success || update.StatusCode != 200 || update.RetryCount != 0 {
+		t.Errorf("update = success %v, status %d, retries %d; want a successful first attempt",
+			update.Success, update.StatusCode, update.RetryCount)
+	}
+	if update.Title != "Latest Judgments" {
+		t.Errorf("Title = %q, want %q", update.Title, "Latest Judgments")
+	}
+	if update.Hash == "" || update.BodyHash == "" {
+		t.Error("rendered page was not hashed")
+	}
+	if !strings.HasPrefix(update.ContentType, "text/html") {
+		t.Errorf("ContentType = %q, want the serialized DOM as HTML", update.ContentType)
+	}
+
+	want := RenderRequest{
+		URL:             src.URL,
+		UserAgent:       "LegiTrack-Test/1.0",
+		WaitForSelector: "#results",
+		WaitNetworkIdle: true,
+	}
+	if len(renderer.requests) != 1 || renderer.requests[0] != want {
+		t.Errorf("requests = %+v, want one %+v", renderer.requests, want)
+	}
+}
+
+func TestBrowserScraperRetriesFailedRenders(t *testing.T) {
+	renderer := &fakeRenderer{render: func(ctx context.Context, req RenderRequest, call int) (*RenderResult, error) {
+		if call == 1 {
+			return nil, errors.New("page crashed")
+		}
+		return &RenderResult{HTML: []byte(renderedPage)}, nil
+	}}
+	src := Source{ID: "court", URL: "https://court.example/judgments", MaxRetries: 2}
+
+	out := make(chan Update, 1)
+	if err := newTestBrowserScraper(renderer).Scrape(context.Background(), src, out); err != nil {
+		t.Fatalf("Scrape: %v", err)
+	}
+	if update := <-out; !update.Success || update.RetryCount != 1 {
+		t.Errorf("update = success %v, retries %d; want success after one retry", update.Success, update.RetryCount)
+	}
+}
+
+func TestBrowserScraperRejectsErrorStatus(t *testing.T) {
+	renderer := &fakeRenderer{render: func(ctx context.Context, req RenderRequest, call int) (*RenderResult, error) {
+		return &RenderResult{HTML: []byte("<html><body>Not Found</body></html>"), StatusCode: 404}, nil
+	}}
+	src := Source{ID: "court", URL: "https://court.example/missing", MaxRetries: 3}
+
+	out := make(chan Update, 1)
+	if err := newTestBrowserScraper(renderer).Scrape(context.Background(), src, out); err == nil {
+		t.Fatal("Scrape succeeded on a 404")
+	}
+	update := <-out
+	if update.Success || update.StatusCode != 404 {
+		t.Errorf("update = success %v, status %d; want a failed 404", update.Success, update.StatusCode)
+	}
+	if renderer.calls() != 1 {
+		t.Errorf("rendered %d times, want a 404 not to be retried", renderer.calls())
+	}
+}
+
+func TestBrowserScraperSelectorTimeout(t *testing.T) {
+	// The selector never appears, so the renderer waits until the run's
+	// deadline, as chromedp does
+	renderer := &fakeRenderer{render: func(ctx context.Context, req RenderRequest, call int) (*RenderResult, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}}
+	sm := NewScraperManager()
+	sm.browserScraper = newTestBrowserScraper(renderer)
+	src := Source{
+		ID:              "court",
+		URL:             "https://court.example/judgments",
+		JSRendered:      true,
+		WaitForSelector: "#never",
+		Timeout:         200 * time.Millisecond,
+		MaxRetries:      3,
+	}
+
+	out := make(chan Update, 1)
+	start := time.Now()
+	err := sm.Scrape(context.Background(), src, out)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Scrape error = %v, want the deadline exceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Scrape took %s; retries should not outlast the source's timeout", elapsed)
+	}
+	if renderer.calls() != 1 {
+		t.Errorf("rendered %d times, want no retry after the deadline", renderer.calls())
+	}
+	if update := <-out; update.Success || update.ErrorDetail == "" {
+		t.Errorf("update = success %v, error %q; want a failed update", update.Success, update.ErrorDetail)
+	}
+}
+
+// writeScript writes an executable shell script for the command renderer
+func writeScript(t *testing.T, body string) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("render command tests need a POSIX shell")
+	}
+	path := filepath.Join(t.TempDir(), "render.sh")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+body), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestCommandRenderer(t *testing.T) {
+	script := writeScript(t, `read url
+printf '<html><body data-ua="%s" data-wait="%s" data-idle="%s">%s</body></html>' \
+	"$LEGITRACK_USER_AGENT" "$LEGITRACK_WAIT_SELECTOR" "$LEGITRACK_WAIT_NETWORK_IDLE" "$url"
+`)
+
+	result, err := NewCommandRenderer([]string{script}).Render(context.Background(), RenderRequest{
+		URL:             "https://court.example/judgments",
+		UserAgent:       "LegiTrack-Test/1.0",
+		WaitForSelector: "#results",
+		WaitNetworkIdle: true,
+	})
+	if err != nil {
+		t.Fatalf("Render: %v", err)
+	}
+
+	want := `<html><body data-ua="LegiTrack-Test/1.0" data-wait="#results" data-idle="true">https://court.example/judgments</body></html>`
+	if string(result.HTML) != want {
+		t.Errorf("HTML = %s\nwant   %s", result.HTML, want)
+	}
+	if result.StatusCode != 0 {
+		t.Errorf("StatusCode = %d, want 0 as a command cannot see it", result.StatusCode)
+	}
+}
+
+func TestCommandRendererFailures(t *testing.T) {
+	t.Run("exit status", func(t *testing.T) {
+		script := writeScript(t, "echo 'loading page' >&2\necho 'selector #results not found' >&2\nexit 3\n")
+		_, err := NewCommandRenderer([]string{script}).Render(context.Background(), RenderRequest{URL: "https://court.example/"})
+		if err == nil || !strings.Contains(err.Error(), "selector #results not found") {
+			t.Fatalf("error = %v, want the last line of stderr", err)
+		}
+		if !isRetryableRenderError(err) {
+			t.Error("a failed render should be retried")
+		}
+	})
+
+	t.Run("no output", func(t *testing.T) {
+		script := writeScript(t, "exit 0\n")
+		if _, err := NewCommandRenderer([]string{script}).Render(context.Background(), RenderRequest{URL: "https://court.example/"}); err == nil {
+			t.Fatal("Render succeeded without output")
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		script := writeScript(t, "exec sleep 10\n")
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+
+		start := time.Now()
+		_, err := NewCommandRenderer([]string{script}).Render(ctx, RenderRequest{URL: "https://court.example/"})
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("error = %v, want the deadline exceeded", err)
+		}
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Errorf("Render took %s; the command should be killed at the deadline", elapsed)
+		}
+	})
+
+	t.Run("missing command", func(t *testing.T) {
+		_, err := NewCommandRenderer([]string{filepath.Join(t.TempDir(), "missing")}).Render(context.Background(), RenderRequest{URL: "https://court.example/"})
+		if err == nil || isRetryableRenderError(err) {
+			t.Fatalf("error = %v, want a failure that is not retried", err)
+		}
+	})
+}
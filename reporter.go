@@ -16,10 +16,18 @@ type ReportData struct {
 	Date        string
 	DailyStats  map[string]interface{}
 	SourceStats map[string]map[string]interface{}
-	Updates     []Update
+	Updates     []ReportUpdate
+	NewItems    map[string][]Item // by source, for sources with an extraction spec
 	Sources     map[string]SourceConfig
 }
 
+// ReportUpdate is an update as shown in a report, with what changed since
+// the source's previous version when it can be determined
+type ReportUpdate struct {
+	Update
+	Change *ChangeExcerpt
+}
+
 // Reporter handles HTML report generation
 type Reporter struct {
 	storage   Storage
@@ -63,12 +71,38 @@ func (r *Reporter) GenerateDailyReport(ctx context.Context, date time.Time) erro
 		return fmt.Errorf("failed to get updates: %w", err)
 	}
 
+	// Get the notices first listed on the date
+	items, err := r.storage.GetItemsByDateRange(ctx, date, date)
+	if err != nil {
+		return fmt.Errorf("failed to get items: %w", err)
+	}
+
+	newItems := make(map[string][]Item)
+	for _, item := range items {
+		newItems[item.SourceID] = append(newItems[item.SourceID], item)
+	}
+
+	// Work out what changed for each new version
+	normalizers := make(map[string]*Normalizer)
+	for _, src := range r.config.GetSources() {
+		normalizers[src.ID] = src.Normalizer
+	}
+
+	reportUpdates := make([]ReportUpdate, 0, len(updates))
+	for _, update := range updates {
+		reportUpdates = append(reportUpdates, ReportUpdate{
+			Update: update,
+			Change: r.changeExcerpt(ctx, update, normalizers[update.SourceID]),
+		})
+	}
+
 	// Create report data
 	reportData := ReportData{
 		Date:        date.Format("2006-01-02"),
 		DailyStats:  dailyStats,
 		SourceStats: sourceStats,
-		Updates:     updates,
+		Updates:     reportUpdates,
+		NewItems:    newItems,
 		Sources:     r.config.Sources,
 	}
 
@@ -90,6 +124,16 @@ func (r *Reporter) GenerateDailyReport(ctx context.Context, date time.Time) erro
 	return nil
 }
 
+// changeExcerpt diffs an update against its source's previous version,
+// logging rather than failing the report when that is not possible
+func (r *Reporter) changeExcerpt(ctx context.Context, update Update, normalizer *Normalizer) *ChangeExcerpt {
+	excerpt, err := changeExcerpt(ctx, r.storage, normalizer, update)
+	if err != nil {
+		log.Printf("[REPORTER] %v", err)
+	}
+	return excerpt
+}
+
 // generateHTML generates the HTML content for the report
 func (r *Reporter) generateHTML(data ReportData) (string, error) {
 	const htmlTemplate = `<!DOCTYPE html>
@@ -226,6 +270,60 @@ func (r *Reporter) generateHTML(data ReportData) (string, error) {
         .update-link:hover {
             text-decoration: underline;
         }
+        .update-retries {
+            margin-left: 10px;
+            color: #b8860b;
+        }
+        .update-reverted {
+            margin-left: 10px;
+            color: #c0392b;
+            font-weight: bold;
+        }
+        .update-change {
+            background: #f8f9fa;
+            border-radius: 5px;
+            padding: 10px 15px;
+            margin: 10px 0;
+            font-size: 0.9em;
+        }
+        .change-stats {
+            color: #666;
+            margin-bottom: 5px;
+        }
+        .change-block {
+            border-top: 1px dashed #dee2e6;
+            padding: 5px 0;
+        }
+        .change-block del {
+            color: #dc3545;
+            background: #fbe9eb;
+        }
+        .change-block ins {
+            color: #28a745;
+            background: #e9f6ec;
+            text-decoration: none;
+        }
+        .change-more {
+            color: #666;
+            font-style: italic;
+        }
+        .items-card {
+            margin-bottom: 15px;
+        }
+        .items-list {
+            margin: 0;
+            padding-left: 20px;
+        }
+        .item-ref {
+            font-family: monospace;
+            color: #333;
+            margin-right: 5px;
+        }
+        .item-date {
+            color: #666;
+            font-size: 0.9em;
+            margin-left: 5px;
+        }
         .error-detail {
             color: #dc3545;
             font-style: italic;
@@ -288,6 +386,26 @@ func (r *Reporter) generateHTML(data ReportData) (string, error) {
                 </div>
             </div>
 
+            {{if .NewItems}}
+            <div class="section">
+                <h2>New Items</h2>
+                {{range $sourceID, $items := .NewItems}}
+                <div class="source-card items-card">
+                    <div class="source-name">{{$sourceID}}: {{len $items}} new</div>
+                    <ul class="items-list">
+                        {{range $items}}
+                        <li>
+                            {{if .Reference}}<span class="item-ref">{{.Reference}}</span>{{end}}
+                            {{if .Link}}<a href="{{.Link}}" class="update-link" target="_blank">{{or .Title .Link}}</a>{{else}}{{.Title}}{{end}}
+                            {{if .DateText}}<span class="item-date">{{.DateText}}</span>{{end}}
+                        </li>
+                        {{end}}
+                    </ul>
+                </div>
+                {{end}}
+            </div>
+            {{end}}
+
             <div class="section">
                 <h2>Updates</h2>
                 {{if .Updates}}
@@ -309,9 +427,31 @@ func (r *Reporter) generateHTML(data ReportData) (string, error) {
                             {{if .Hash}}
                             <span style="margin-left: 10px; color: #666;">Hash: {{.Hash}}</span>
                             {{end}}
+                            {{if .RetryCount}}
+                            <span class="update-retries">Retries: {{.RetryCount}}</span>
+                            {{end}}
+                            {{if .Reverted}}
+                            <span class="update-reverted">Reverted to an earlier version</span>
+                            {{end}}
+                        </div>
+                        {{with .Change}}
+                        <div class="update-change">
+                            <div class="change-stats">What changed: +{{.Added}} / -{{.Removed}} lines</div>
+                            {{range .Blocks}}
+                            <div class="change-block">
+                                {{if .Words}}
+                                {{range .Words}}{{if .IsDelete}}<del>{{clip .Text}}</del> {{else if .IsInsert}}<ins>{{clip .Text}}</ins> {{else}}{{clip .Text}} {{end}}{{end}}
+                                {{else}}
+                                {{range .Removed}}<div><del>- {{clip .}}</del></div>{{end}}
+                                {{range .Added}}<div><ins>+ {{clip .}}</ins></div>{{end}}
+                                {{end}}
+                            </div>
+                            {{end}}
+                            {{if .Truncated}}<div class="change-more">More changes not shown; run <code>legitrack diff</code> for the full diff.</div>{{end}}
                         </div>
+                        {{end}}
                         {{if not .Success}}
-                        <div class="error-detail">Error: {{.ErrorDetail}}</div>
+                        <div class="error-detail">{{if .BlockedByRobots}}Skipped{{else}}Error{{end}}: {{.ErrorDetail}}</div>
                         {{end}}
                     </li>
                     {{end}}
@@ -330,7 +470,8 @@ func (r *Reporter) generateHTML(data ReportData) (string, error) {
 </html>`
 
 	tmpl, err := template.New("report").Funcs(template.FuncMap{
-		"now": time.Now,
+		"now":  time.Now,
+		"clip": clip,
 	}).Parse(htmlTemplate)
 	if err != nil {
 		return "", err
@@ -344,6 +485,11 @@ func (r *Reporter) generateHTML(data ReportData) (string, error) {
 	return buf.String(), nil
 }
 
+// clip shortens long lines of changed text for display
+func clip(s string) string {
+	return clipText(s, 200)
+}
+
 // GenerateIndexReport generates an index page with links to all daily reports
 func (r *Reporter) GenerateIndexReport(ctx context.Context) error {
 	// This would list all available reports
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// Backoff controls the delay between retry attempts
+type Backoff struct {
+	Base          time.Duration
+	Max           time.Duration
+	MaxRetryAfter time.Duration
+}
+
+// DefaultBackoff returns the backoff used when none is configured
+func DefaultBackoff() Backoff {
+	return Backoff{
+		Base:          1 * time.Second,
+		Max:           30 * time.Second,
+		MaxRetryAfter: 2 * time.Minute,
+	}
+}
+
+// Delay returns the jittered delay before the given retry (1-based).
+// Half of the exponential delay is fixed and the other half is random so
+// that sources sharing a host do not retry in lockstep.
+func (b Backoff) Delay(retry int) time.Duration {
+	d := b.Base
+	for i := 1; i < retry && d < b.Max; i++ {
+		d *= 2
+	}
+	if d > b.Max {
+		d = b.Max
+	}
+	if d <= 0 {
+		return 0
+	}
+
+	half := d / 2
+	return half + time.Duration(rand.Int64N(int64(d-half)+1))
+}
+
+// fetchError describes a failed fetch attempt and whether it is worth retrying
+type fetchError struct {
+	StatusCode int
+	Retryable  bool
+	RetryAfter time.Duration
+	Err        error
+}
+
+func (e *fetchError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *fetchError) Unwrap() error {
+	return e.Err
+}
+
+// classifyError wraps a transport-level error with its retry classification
+func classifyError(err error) *fetchError {
+	return &fetchError{Retryable: isRetryableError(err), Err: err}
+}
+
+// isRetryableError reports whether a transport error is likely to be transient
+func isRetryableError(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	// A name that does not exist will not start existing on the next attempt,
+	// but resolver timeouts and SERVFAILs are common on government DNS.
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTimeout || dnsErr.IsTemporary || !dnsErr.IsNotFound
+	}
+
+	// Certificate problems need a human, not another attempt
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidCert x509.CertificateInvalidError
+	var verifyErr *tls.CertificateVerificationError
+	if errors.As(err, &unknownAuthority) || errors.As(err, &hostnameErr) ||
+		errors.As(err, &invalidCert) || errors.As(err, &verifyErr) {
+		return false
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	if errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+		return true
+	}
+
+	var opErr *net.OpError
+	return errors.As(err, &opErr)
+}
+
+// statusError builds a fetchError for a response whose status code indicates failure
+func statusError(resp *http.Response) *fetchError {
+	return newStatusError(resp.StatusCode, resp.Header.Get("Retry-After"))
+}
+
+// newStatusError classifies an unexpected status code, honoring Retry-After
+// for the codes that are retried
+func newStatusError(code int, retryAfter string) *fetchError {
+	fe := &fetchError{
+		StatusCode: code,
+		Err:        fmt.Errorf("unexpected HTTP status %d %s", code, http.StatusText(code)),
+	}
+
+	switch {
+	case code == http.StatusTooManyRequests,
+		code == http.StatusRequestTimeout:
+		fe.Retryable = true
+	case code >= 500 && code != http.StatusNotImplemented &&
+		code != http.StatusHTTPVersionNotSupported:
+		fe.Retryable = true
+	}
+
+	if fe.Retryable {
+		fe.RetryAfter = parseRetryAfter(retryAfter, time.Now())
+	}
+
+	return fe
+}
+
+// parseRetryAfter parses a Retry-After header given either in seconds or as an HTTP date
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+
+	if secs, err := strconv.Atoi(value); err == nil {
+		if secs < 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+
+	if at, err := http.ParseTime(value); err == nil {
+		if d := at.Sub(now); d > 0 {
+			return d
+		}
+	}
+
+	return 0
+}
+
+// retry calls fn until it succeeds, fails terminally, or maxRetries retries
+// have been used. It returns the number of attempts made.
+func retry(ctx context.Context, label string, maxRetries int, backoff Backoff, fn func(attempt int) error) (int, error) {
+	if maxRetries < 0 {
+		maxRetries = 0
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := fn(attempt)
+		if err == nil {
+			return attempt, nil
+		}
+
+		if ctx.Err() != nil {
+			return attempt, err
+		}
+
+		var fe *fetchError
+		if !errors.As(err, &fe) || !fe.Retryable {
+			return attempt, err
+		}
+
+		if attempt > maxRetries {
+			return attempt, fmt.Errorf("giving up after %d attempts: %w", attempt, err)
+		}
+
+		delay := backoff.Delay(attempt)
+		if fe.RetryAfter > 0 {
+			if backoff.MaxRetryAfter > 0 && fe.RetryAfter > backoff.MaxRetryAfter {
+				return attempt, fmt.Errorf("server asked to retry after %s, exceeding limit of %s: %w",
+					fe.RetryAfter, backoff.MaxRetryAfter, err)
+			}
+			if fe.RetryAfter > delay {
+				delay = fe.RetryAfter
+			}
+		}
+
+		log.Printf("[RETRY] %s attempt %d/%d failed: %v (retrying in %s)",
+			label, attempt, maxRetries+1, err, delay.Round(time.Millisecond))
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return attempt, fmt.Errorf("retry aborted: %w", ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
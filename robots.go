@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultRobotsTTL is how long a fetched robots.txt is trusted
+	defaultRobotsTTL = 24 * time.Hour
+	// maxRobotsSize is the part of a robots.txt that is parsed (RFC 9309 §2.5)
+	maxRobotsSize = 500 << 10
+	// maxCrawlDelay caps the Crawl-delay honored, so a bogus value cannot stall a source
+	maxCrawlDelay = time.Minute
+)
+
+// ErrBlockedByRobots marks a fetch refused because robots.txt disallows it
+var ErrBlockedByRobots = errors.New("blocked by robots.txt")
+
+// RobotsCache keeps the robots.txt of each origin for a TTL, along with when
+// the origin was last requested so Crawl-delay can be honored
+type RobotsCache struct {
+	ttl   time.Duration
+	mu    sync.Mutex
+	hosts map[string]*robotsHost
+}
+
+// robotsHost is the cached robots.txt of one origin. Its lock is held while
+// robots.txt is fetched and while waiting out a Crawl-delay, so requests to
+// the origin are spaced even when several sources share it.
+type robotsHost struct {
+	mu          sync.Mutex
+	rules       *robotsRules
+	fetchedAt   time.Time
+	lastRequest time.Time
+}
+
+// NewRobotsCache creates a cache that refreshes robots.txt files after ttl
+func NewRobotsCache(ttl time.Duration) *RobotsCache {
+	if ttl <= 0 {
+		ttl = defaultRobotsTTL
+	}
+	return &RobotsCache{ttl: ttl, hosts: make(map[string]*robotsHost)}
+}
+
+// host returns the cache entry of an origin, creating it if needed
+func (c *RobotsCache) host(origin string) *robotsHost {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.hosts[origin]
+	if !ok {
+		entry = &robotsHost{}
+		c.hosts[origin] = entry
+	}
+	return entry
+}
+
+// checkRobots refuses target if its origin's robots.txt disallows it for our
+// user agent, and otherwise waits out the origin's Crawl-delay. Sources with
+// ignore_robots set skip the check entirely.
+func (h *HTTPScraper) checkRobots(ctx context.Context, src Source, target string) error {
+	if h.robots == nil || src.IgnoreRobots {
+		return nil
+	}
+
+	u, err := url.Parse(target)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil
+	}
+	origin := u.Scheme + "://" + u.Host
+
+	entry := h.robots.host(origin)
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+
+	if entry.rules == nil || time.Since(entry.fetchedAt) > h.robots.ttl {
+		rules, err := h.fetchRobots(ctx, src, origin)
+		switch {
+		case err == nil:
+			entry.rules = rules
+			entry.fetchedAt = time.Now()
+		case entry.rules != nil:
+			log.Printf("[ROBOTS] Could not refresh %s/robots.txt, keeping the cached copy: %v", origin, err)
+		default:
+			return err
+		}
+	}
+
+	agent := robotsAgent(h.userAgent)
+	group := entry.rules.group(agent)
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	if u.RawQuery != "" {
+		path += "?" + u.RawQuery
+	}
+	if !group.allowed(path) {
+		return &fetchError{Err: fmt.Errorf("%w: %s/robots.txt disallows %s for %s", ErrBlockedByRobots, origin, path, agent)}
+	}
+
+	if group.crawlDelay > 0 && !entry.lastRequest.IsZero() {
+		if wait := time.Until(entry.lastRequest.Add(group.crawlDelay)); wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			case <-timer.C:
+			}
+		}
+	}
+	entry.lastRequest = time.Now()
+	return nil
+}
+
+// fetchRobots downloads and parses an origin's robots.txt. A missing file
+// (any 4xx) allows everything; a 5xx or transport error is returned as a
+// retryable failure, since RFC 9309 treats an unreachable robots.txt as a
+// full disallow rather than a permission.
+func (h *HTTPScraper) fetchRobots(ctx context.Context, src Source, origin string) (*robotsRules, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", origin+"/robots.txt", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create robots.txt request: %w", err)
+	}
+	req.Header.Set("User-Agent", h.userAgent)
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, classifyError(fmt.Errorf("failed to fetch robots.txt: %w", err))
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		body, err := io.ReadAll(io.LimitReader(resp.Body, maxRobotsSize))
+		if err != nil {
+			return nil, classifyError(fmt.Errorf("failed to read robots.txt: %w", err))
+		}
+		log.Printf("[ROBOTS] Loaded %s/robots.txt", origin)
+		return parseRobots(body), nil
+	case resp.StatusCode >= 400 && resp.StatusCode < 500:
+		return &robotsRules{}, nil
+	default:
+		return nil, &fetchError{
+			StatusCode: resp.StatusCode,
+			Retryable:  true,
+			Err:        fmt.Errorf("robots.txt unavailable: HTTP %d", resp.StatusCode),
+		}
+	}
+}
+
+// robotsAgent returns the product token robots.txt groups are matched
+// against: "LegiTrack-Bot" for "LegiTrack-Bot/1.0 (Legal Compliance Monitor)"
+func robotsAgent(userAgent string) string {
+	token := userAgent
+	if i := strings.IndexAny(token, "/ "); i >= 0 {
+		token = token[:i]
+	}
+	return strings.ToLower(token)
+}
+
+// robotsRules is a parsed robots.txt, its groups keyed by lowercased user agent
+type robotsRules struct {
+	groups map[string]*robotsGroup
+}
+
+// robotsGroup holds the rules that apply to one user agent
+type robotsGroup struct {
+	rules      []robotsRule
+	crawlDelay time.Duration
+}
+
+type robotsRule struct {
+	allow   bool
+	pattern string
+}
+
+// group returns the rules for agent, falling back to the "*" group. Without
+// either, everything is allowed.
+func (r *robotsRules) group(agent string) *robotsGroup {
+	if g, ok := r.groups[agent]; ok {
+		return g
+	}
+	if g, ok := r.groups["*"]; ok {
+		return g
+	}
+	return &robotsGroup{}
+}
+
+// allowed reports whether path may be fetched. The longest matching rule
+// wins, and Allow wins a tie (RFC 9309 §2.2.2).
+func (g *robotsGroup) allowed(path string) bool {
+	allowed, longest := true, -1
+	for _, rule := range g.rules {
+		if !robotsMatch(rule.pattern, path) {
+			continue
+		}
+		if len(rule.pattern) > longest || (len(rule.pattern) == longest && rule.allow) {
+			allowed, longest = rule.allow, len(rule.pattern)
+		}
+	}
+	return allowed
+}
+
+// robotsMatch matches a path against a robots.txt pattern, where "*" matches
+// any run of characters and a trailing "$" anchors the end of the path
+func robotsMatch(pattern, path string) bool {
+	anchored := strings.HasSuffix(pattern, "$")
+	pattern = strings.TrimSuffix(pattern, "$")
+
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(path, parts[0]) {
+		return false
+	}
+	rest := path[len(parts[0]):]
+	for i, part := range parts[1:] {
+		if anchored && i == len(parts)-2 {
+			return strings.HasSuffix(rest, part)
+		}
+		idx := strings.Index(rest, part)
+		if idx < 0 {
+			return false
+		}
+		rest = rest[idx+len(part):]
+	}
+	return !anchored || rest == ""
+}
+
+// parseRobots parses a robots.txt. Consecutive User-agent lines open a group
+// that the following rules apply to; a group listed twice is merged.
+func parseRobots(body []byte) *robotsRules {
+	rules := &robotsRules{groups: make(map[string]*robotsGroup)}
+
+	var current []*robotsGroup
+	inRules := false
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case "user-agent":
+			if inRules {
+				current, inRules = nil, false
+			}
+			agent := strings.ToLower(value)
+			g, ok := rules.groups[agent]
+			if !ok {
+				g = &robotsGroup{}
+				rules.groups[agent] = g
+			}
+			current = append(current, g)
+		case "allow", "disallow":
+			inRules = true
+			if value == "" {
+				// An empty Disallow allows everything, the same as no rule
+				continue
+			}
+			for _, g := range current {
+				g.rules = append(g.rules, robotsRule{allow: key == "allow", pattern: value})
+			}
+		case "crawl-delay":
+			inRules = true
+			seconds, err := strconv.ParseFloat(value, 64)
+			if err != nil || seconds <= 0 {
+				continue
+			}
+			delay := time.Duration(seconds * float64(time.Second))
+			if delay > maxCrawlDelay {
+				delay = maxCrawlDelay
+			}
+			for _, g := range current {
+				g.crawlDelay = delay
+			}
+		}
+	}
+	return rules
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Source severities, lowest first
+const (
+	SeverityLow    = "low"
+	SeverityMedium = "medium"
+	SeverityHigh   = "high"
+)
+
+// severityRank orders severities so routes can ask for a minimum
+var severityRank = map[string]int{
+	SeverityLow:    1,
+	SeverityMedium: 2,
+	SeverityHigh:   3,
+}
+
+// Route selects notifications for its own recipients and webhooks. A
+// notification matches when it meets every criterion the route sets.
+type Route struct {
+	Name       string
+	Sources    map[string]bool
+	Categories map[string]bool
+	Keywords   []string // lower case
+	Severity   string   // minimum severity, empty for any
+}
+
+// newRoute validates a route's configuration
+func newRoute(cfg RouteConfig) (*Route, error) {
+	if cfg.Name == "" {
+		return nil, errors.New("route without a name")
+	}
+	if len(cfg.Recipients) == 0 && len(cfg.Webhooks) == 0 {
+		return nil, fmt.Errorf("route %s has no recipients or webhooks", cfg.Name)
+	}
+
+	severity := strings.ToLower(cfg.Severity)
+	if _, ok := severityRank[severity]; severity != "" && !ok {
+		return nil, fmt.Errorf("route %s has unknown severity %q", cfg.Name, cfg.Severity)
+	}
+
+	r := &Route{Name: cfg.Name, Severity: severity}
+	if len(cfg.Sources) > 0 {
+		r.Sources = make(map[string]bool)
+		for _, id := range cfg.Sources {
+			r.Sources[id] = true
+		}
+	}
+	if len(cfg.Categories) > 0 {
+		r.Categories = make(map[string]bool)
+		for _, category := range cfg.Categories {
+			r.Categories[category] = true
+		}
+	}
+	for _, keyword := range cfg.Keywords {
+		if keyword = strings.TrimSpace(keyword); keyword != "" {
+			r.Keywords = append(r.Keywords, strings.ToLower(keyword))
+		}
+	}
+	return r, nil
+}
+
+// Matches reports whether a notification is for this route
+func (r *Route) Matches(n Notification) bool {
+	if r.Sources != nil && !r.Sources[n.SourceID] {
+		return false
+	}
+	if r.Categories != nil && !r.Categories[n.Category] {
+		return false
+	}
+	if r.Severity != "" && severityRank[n.Severity] < severityRank[r.Severity] {
+		return false
+	}
+	if len(r.Keywords) > 0 {
+		return r.mentions(n)
+	}
+	return true
+}
+
+// mentions reports whether the title, summary or changed text of a
+// notification contains any of the route's keywords
+func (r *Route) mentions(n Notification) bool {
+	text := strings.ToLower(n.Title + "\n" + n.Summary + "\n" + n.ChangedText)
+	for _, keyword := range r.Keywords {
+		if strings.Contains(text, keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+// routedNotifier delivers only the notifications matched by one of its
+// routes, under a channel name of its own
+type routedNotifier struct {
+	Notifier
+	name   string
+	routes []*Route
+}
+
+// Name identifies the channel in the outbox and logs
+func (r *routedNotifier) Name() string {
+	return r.name
+}
+
+// Handles reports whether the notifier takes the notification and a route
+// matches it
+func (r *routedNotifier) Handles(n Notification) bool {
+	if !r.Notifier.Handles(n) {
+		return false
+	}
+	for _, route := range r.routes {
+		if route.Matches(n) {
+			return true
+		}
+	}
+	return false
+}
+
+// configuredRoute is a validated route with its destinations
+type configuredRoute struct {
+	*Route
+	Recipients []string
+	Webhooks   []string
+}
+
+// newRoutes validates the configured routes, logging and skipping those that
+// are misconfigured
+func newRoutes(cfg NotificationConfig) []configuredRoute {
+	var routes []configuredRoute
+	seen := make(map[string]bool)
+
+	for _, routeCfg := range cfg.Routes {
+		route, err := newRoute(routeCfg)
+		if err == nil && seen[route.Name] {
+			err = fmt.Errorf("route %s is defined twice", route.Name)
+		}
+		if err == nil {
+			for _, name := range routeCfg.Webhooks {
+				if _, ok := cfg.Webhooks[name]; !ok {
+					err = fmt.Errorf("route %s uses undefined webhook %q", route.Name, name)
+				}
+			}
+		}
+		if err == nil && len(routeCfg.Recipients) > 0 && !cfg.Email.Enabled {
+			err = fmt.Errorf("route %s has recipients but email is not enabled", route.Name)
+		}
+		if err != nil {
+			log.Printf("[NOTIFY] Ignoring route: %v", err)
+			continue
+		}
+
+		// A disabled webhook gets nothing, whichever routes name it
+		var webhooks []string
+		for _, name := range routeCfg.Webhooks {
+			if !cfg.Webhooks[name].Enabled {
+				log.Printf("[NOTIFY] Route %s: webhook %s is not enabled", route.Name, name)
+				continue
+			}
+			webhooks = append(webhooks, name)
+		}
+		if len(routeCfg.Recipients) == 0 && len(webhooks) == 0 {
+			log.Printf("[NOTIFY] Ignoring route: route %s has no enabled webhooks", route.Name)
+			continue
+		}
+
+		seen[route.Name] = true
+		routes = append(routes, configuredRoute{Route: route, Recipients: routeCfg.Recipients, Webhooks: webhooks})
+	}
+
+	return routes
+}
+
+// newRoutedNotifiers creates a channel for each instant recipient of the
+// routes, mailed through the configured SMTP server, and one for each named
+// webhook the routes use. Each gets an event once, however many of its
+// routes match; default recipients get every event already, so they get
+// nothing more from routes.
+func newRoutedNotifiers(cfg NotificationConfig, routes []configuredRoute) []Notifier {
+	var notifiers []Notifier
+
+	defaults := make(map[string]bool)
+	for _, address := range deliveryRecipients(cfg, cfg.Email.Recipients, DeliveryInstant) {
+		defaults[recipientKey(address)] = true
+	}
+	emailRoutes := make(map[string][]*Route)
+	emailAddresses := make(map[string]string)
+	var emailKeys []string
+
+	webhookRoutes := make(map[string][]*Route)
+	var webhookNames []string
+
+	for _, route := range routes {
+		for _, address := range deliveryRecipients(cfg, route.Recipients, DeliveryInstant) {
+			key := recipientKey(address)
+			if defaults[key] {
+				continue
+			}
+			if emailRoutes[key] == nil {
+				emailKeys = append(emailKeys, key)
+				emailAddresses[key] = address
+			}
+			emailRoutes[key] = append(emailRoutes[key], route.Route)
+		}
+
+		for _, name := range route.Webhooks {
+			if webhookRoutes[name] == nil {
+				webhookNames = append(webhookNames, name)
+			}
+			webhookRoutes[name] = append(webhookRoutes[name], route.Route)
+		}
+	}
+
+	for _, key := range emailKeys {
+		emailCfg := cfg.Email
+		emailCfg.Recipients = []string{emailAddresses[key]}
+		email, err := NewEmailNotifier(emailCfg)
+		if err != nil {
+			log.Printf("[NOTIFY] Email to %s disabled: %v", emailAddresses[key], err)
+			continue
+		}
+		notifiers = append(notifiers, &routedNotifier{Notifier: email, name: "email:" + key, routes: emailRoutes[key]})
+	}
+
+	for _, name := range webhookNames {
+		webhook, err := NewWebhookNotifier(cfg.Webhooks[name])
+		if err != nil {
+			log.Printf("[NOTIFY] Webhook %s disabled: %v", name, err)
+			continue
+		}
+		notifiers = append(notifiers, &routedNotifier{Notifier: webhook, name: "webhook:" + name, routes: webhookRoutes[name]})
+	}
+
+	return notifiers
+}
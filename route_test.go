@@ -0,0 +1,84 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// testRoutingConfig mails a default recipient and two routes sharing a
+// recipient and a webhook, with a second webhook that is not enabled
+func testRoutingConfig() NotificationConfig {
+	return NotificationConfig{
+		Email: EmailConfig{
+			Enabled:    true,
+			SMTPServer: "smtp.example.com",
+			SMTPPort:   587,
+			Security:   EmailSecurityNone,
+			From:       "alerts@legitrack.example",
+			Recipients: []string{"legal@example.com"},
+		},
+		Webhooks: map[string]WebhookConfig{
+			"banking": {Enabled: true, URL: "https://hooks.example.com/banking", Secret: "s3cret"},
+			"archive": {URL: "https://hooks.example.com/archive", Secret: "s3cret"},
+		},
+		Routes: []RouteConfig{
+			{
+				Name:       "banking",
+				Categories: []string{"banking_regulations"},
+				Recipients: []string{"Banking Team <banking@example.com>", "legal@example.com"},
+				Webhooks:   []string{"banking", "archive"},
+			},
+			{
+				Name:       "kyc",
+				Keywords:   []string{"KYC"},
+				Recipients: []string{"BANKING@example.com"},
+				Webhooks:   []string{"banking"},
+			},
+			{
+				Name:     "audit",
+				Webhooks: []string{"archive"},
+			},
+		},
+	}
+}
+
+func TestRoutedNotifiersDeliverOncePerDestination(t *testing.T) {
+	cfg := testRoutingConfig()
+	notifiers := newNotifiers(cfg, newRoutes(cfg))
+
+	var names []string
+	for _, notifier := range notifiers {
+		names = append(names, notifier.Name())
+	}
+	want := []string{"email", "email:banking@example.com", "webhook:banking"}
+	if strings.Join(names, ",") != strings.Join(want, ",") {
+		t.Fatalf("channels = %v, want %v", names, want)
+	}
+
+	n := baseNotification(EventUpdate, Source{ID: "rbi", Category: "banking_regulations"}, Update{SourceID: "rbi", Title: "KYC norms revised"})
+	handled := 0
+	for _, notifier := range notifiers {
+		if notifier.Handles(n) {
+			handled++
+		}
+	}
+	if handled != len(notifiers) {
+		t.Errorf("%d of %d channels handle a notification both routes match, want each once", handled, len(notifiers))
+	}
+}
+
+func TestNewRoutesSkipsDisabledWebhooks(t *testing.T) {
+	cfg := testRoutingConfig()
+	routes := newRoutes(cfg)
+
+	if len(routes) != 2 {
+		t.Fatalf("got %d routes, want the route with only a disabled webhook ignored", len(routes))
+	}
+	for _, route := range routes {
+		for _, name := range route.Webhooks {
+			if name == "archive" {
+				t.Errorf("route %s still delivers to the disabled webhook", route.Name)
+			}
+		}
+	}
+}
@@ -2,15 +2,30 @@ package main
 
 import (
 	"context"
-	"crypto/sha256"
-	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
+	"os"
+	"sync/atomic"
 	"time"
 )
 
+// defaultFetchTimeout bounds a run when the source has no usable timeout
+const defaultFetchTimeout = 30 * time.Second
+
+// scrapeTimeout returns the deadline for a whole run of src, covering every
+// attempt and the backoff between them, and any attachments, sitemap
+// children or crawled pages it fetches
+func scrapeTimeout(src Source) time.Duration {
+	if src.Timeout <= 0 {
+		return defaultFetchTimeout
+	}
+	return src.Timeout
+}
+
 // Scraper interface defines the contract for different scraping implementations
 type Scraper interface {
 	Scrape(ctx context.Context, src Source, out chan<- Update) error
@@ -18,22 +33,80 @@ type Scraper interface {
 
 // HTTPScraper handles regular HTTP scraping
 type HTTPScraper struct {
-	client    *http.Client
-	userAgent string
+	client     *http.Client
+	userAgent  string
+	backoff    Backoff
+	timeouts   HTTPTimeouts
+	validators ValidatorStore
+	documents  DocumentIndex
+	robots     *RobotsCache
+	limiter    *HostLimiter
+}
+
+// ValidatorStore provides the cache validators remembered for a source
+type ValidatorStore interface {
+	GetSourceState(ctx context.Context, sourceID string) (*SourceState, error)
+}
+
+// HTTPTimeouts holds the per-phase budgets of a single fetch. A zero value
+// leaves that phase bounded only by the source's overall timeout.
+type HTTPTimeouts struct {
+	Connect        time.Duration
+	TLSHandshake   time.Duration
+	ResponseHeader time.Duration
+	BodyRead       time.Duration
+}
+
+// DefaultHTTPTimeouts returns the phase budgets used when none are configured
+func DefaultHTTPTimeouts() HTTPTimeouts {
+	return HTTPTimeouts{
+		Connect:      10 * time.Second,
+		TLSHandshake: 10 * time.Second,
+	}
 }
 
-// BrowserScraper handles JavaScript-rendered websites (placeholder for now)
+// fetchResult holds the response of a single successful HTTP exchange
+type fetchResult struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+// BrowserScraper handles JavaScript-rendered websites through a Renderer
 type BrowserScraper struct {
-	// Will implement later
+	renderer    Renderer
+	userAgent   string
+	backoff     Backoff
+	attachments AttachmentFetcher
+	limiter     *HostLimiter
 }
 
 // NewHTTPScraper creates a new HTTP scraper
 func NewHTTPScraper() *HTTPScraper {
+	timeouts := DefaultHTTPTimeouts()
 	return &HTTPScraper{
-		client: &http.Client{
-			Timeout: 30 * time.Second,
-		},
+		client:    newHTTPClient(timeouts),
 		userAgent: "LegiTrack-Bot/1.0 (Legal Compliance Monitor)",
+		backoff:   DefaultBackoff(),
+		timeouts:  timeouts,
+		robots:    NewRobotsCache(defaultRobotsTTL),
+	}
+}
+
+// newHTTPClient builds a client whose transport enforces the connect, TLS and
+// header budgets. The overall deadline comes from each request's context.
+func newHTTPClient(timeouts HTTPTimeouts) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{
+		Timeout:   timeouts.Connect,
+		KeepAlive: 30 * time.Second,
+	}).DialContext
+	transport.TLSHandshakeTimeout = timeouts.TLSHandshake
+	transport.ResponseHeaderTimeout = timeouts.ResponseHeader
+
+	return &http.Client{
+		Transport:     transport,
+		CheckRedirect: checkRedirect,
 	}
 }
 
@@ -42,19 +115,184 @@ func (h *HTTPScraper) SetUserAgent(userAgent string) {
 	h.userAgent = userAgent
 }
 
-// NewBrowserScraper creates a new browser scraper
+// SetBackoff sets the delay policy used between retry attempts
+func (h *HTTPScraper) SetBackoff(backoff Backoff) {
+	h.backoff = backoff
+}
+
+// SetTimeouts sets the per-phase fetch budgets and rebuilds the HTTP client
+func (h *HTTPScraper) SetTimeouts(timeouts HTTPTimeouts) {
+	h.timeouts = timeouts
+	h.client = newHTTPClient(timeouts)
+}
+
+// SetValidatorStore enables conditional GETs using the validators in store
+func (h *HTTPScraper) SetValidatorStore(store ValidatorStore) {
+	h.validators = store
+}
+
+// SetRobotsCache sets the robots.txt cache consulted before each request;
+// nil disables robots.txt checks
+func (h *HTTPScraper) SetRobotsCache(cache *RobotsCache) {
+	h.robots = cache
+}
+
+// SetHostLimiter paces requests per host; nil leaves them unpaced
+func (h *HTTPScraper) SetHostLimiter(limiter *HostLimiter) {
+	h.limiter = limiter
+}
+
+// SetDocumentIndex lets attachment fetching re-check known links conditionally
+func (h *HTTPScraper) SetDocumentIndex(index DocumentIndex) {
+	h.documents = index
+}
+
+// conditionalHeaders returns If-None-Match/If-Modified-Since headers for the
+// validators last seen on src, or nil if there are none
+func (h *HTTPScraper) conditionalHeaders(ctx context.Context, src Source) (http.Header, *SourceState) {
+	if h.validators == nil {
+		return nil, nil
+	}
+
+	state, err := h.validators.GetSourceState(ctx, src.ID)
+	if err != nil {
+		log.Printf("[HTTP] Could not load validators for %s: %v", src.ID, err)
+		return nil, nil
+	}
+	if state == nil {
+		return nil, nil
+	}
+	return validatorHeaders(state.ETag, state.LastModified), state
+}
+
+// validatorHeaders returns the If-None-Match/If-Modified-Since headers for a
+// stored ETag and Last-Modified, or nil if both are empty
+func validatorHeaders(etag, lastModified string) http.Header {
+	if etag == "" && lastModified == "" {
+		return nil
+	}
+
+	header := make(http.Header)
+	if etag != "" {
+		header.Set("If-None-Match", etag)
+	}
+	if lastModified != "" {
+		header.Set("If-Modified-Since", lastModified)
+	}
+	return header
+}
+
+// NewBrowserScraper creates a new browser scraper backed by headless Chrome
 func NewBrowserScraper() *BrowserScraper {
-	return &BrowserScraper{}
+	return &BrowserScraper{
+		renderer:  NewChromeRenderer(""),
+		userAgent: "LegiTrack-Bot/1.0 (Legal Compliance Monitor)",
+		backoff:   DefaultBackoff(),
+	}
+}
+
+// SetRenderer sets the backend used to render pages
+func (b *BrowserScraper) SetRenderer(renderer Renderer) {
+	b.renderer = renderer
+}
+
+// SetUserAgent sets the user agent for the browser scraper
+func (b *BrowserScraper) SetUserAgent(userAgent string) {
+	b.userAgent = userAgent
+}
+
+// SetBackoff sets the delay policy used between retry attempts
+func (b *BrowserScraper) SetBackoff(backoff Backoff) {
+	b.backoff = backoff
+}
+
+// SetHostLimiter paces renders per host, sharing the budget of HTTP requests
+func (b *BrowserScraper) SetHostLimiter(limiter *HostLimiter) {
+	b.limiter = limiter
+}
+
+// SetAttachmentFetcher sets how documents linked from rendered pages are downloaded
+func (b *BrowserScraper) SetAttachmentFetcher(fetcher AttachmentFetcher) {
+	b.attachments = fetcher
 }
 
 // Scrape implements HTTP scraping
 func (h *HTTPScraper) Scrape(ctx context.Context, src Source, out chan<- Update) error {
 	log.Printf("[HTTP] Starting scrape of %s", src.URL)
 
+	conditional, state := h.conditionalHeaders(ctx, src)
+
+	var resp *fetchResult
+	attempts, err := retry(ctx, src.ID, src.MaxRetries, h.backoff, func(attempt int) error {
+		r, err := h.fetch(ctx, src, src.URL, conditional)
+		if err != nil {
+			return err
+		}
+		resp = r
+		return nil
+	})
+	if err != nil {
+		// Send error update
+		out <- failedUpdate(src, attempts, err)
+		return fmt.Errorf("failed to fetch URL: %w", err)
+	}
+
+	etag := resp.Header.Get("ETag")
+	lastModified := resp.Header.Get("Last-Modified")
+
+	// The server confirmed our cached copy is current
+	if resp.StatusCode == http.StatusNotModified && conditional != nil {
+		if etag == "" {
+			etag = state.ETag
+		}
+		if lastModified == "" {
+			lastModified = state.LastModified
+		}
+
+		out <- Update{
+			SourceID:     src.ID,
+			URL:          src.URL,
+			FetchedAt:    time.Now().UTC(),
+			StatusCode:   resp.StatusCode,
+			Success:      true,
+			RetryCount:   attempts - 1,
+			NotModified:  true,
+			ETag:         etag,
+			LastModified: lastModified,
+		}
+
+		log.Printf("[HTTP] Not modified since last check: %s (attempts: %d)", src.URL, attempts)
+		return nil
+	}
+
+	// Send successful update
+	out <- h.pageUpdate(ctx, src, resp, attempts)
+
+	log.Printf("[HTTP] Successfully scraped %s (status: %d, size: %d bytes, attempts: %d)",
+		src.URL, resp.StatusCode, len(resp.Body), attempts)
+	return nil
+}
+
+// fetch performs a single GET request and reads the full response body
+// within the run's deadline, adding any extra request headers. Failures
+// worth retrying are returned as *fetchError. Requests robots.txt disallows
+// fail with ErrBlockedByRobots without being sent.
+func (h *HTTPScraper) fetch(ctx context.Context, src Source, url string, header http.Header) (*fetchResult, error) {
+	if err := h.checkRobots(ctx, src, url); err != nil {
+		return nil, err
+	}
+	if err := h.limiter.Wait(ctx, url); err != nil {
+		return nil, err
+	}
+
 	// Create request with context
-	req, err := http.NewRequestWithContext(ctx, "GET", src.URL, nil)
+	req, err := http.NewRequestWithContext(withRedirectLimit(ctx, src), "GET", url, nil)
 	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	for key, values := range header {
+		req.Header[key] = values
 	}
 
 	// Set user agent
@@ -63,63 +301,304 @@ func (h *HTTPScraper) Scrape(ctx context.Context, src Source, out chan<- Update)
 	// Make the request
 	resp, err := h.client.Do(req)
 	if err != nil {
-		// Send error update
-		out <- Update{
-			SourceID:    src.ID,
-			URL:         src.URL,
-			FetchedAt:   time.Now().UTC(),
-			Success:     false,
-			ErrorDetail: err.Error(),
-		}
-		return fmt.Errorf("failed to fetch URL: %w", err)
+		return nil, classifyError(err)
 	}
 	defer resp.Body.Close()
 
+	// A 304 is only meaningful as the answer to our conditional request
+	notModified := resp.StatusCode == http.StatusNotModified && len(header) > 0
+
+	// Server errors and throttling are retried; other unexpected statuses
+	// fail immediately so error pages never enter the change history
+	if !notModified && !statusAccepted(src, resp.StatusCode) {
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+		return nil, statusError(resp)
+	}
+
 	// Read response body
-	body, err := io.ReadAll(resp.Body)
+	body, err := h.readBody(resp)
 	if err != nil {
-		out <- Update{
-			SourceID:    src.ID,
-			URL:         src.URL,
-			FetchedAt:   time.Now().UTC(),
-			StatusCode:  resp.StatusCode,
-			Success:     false,
-			ErrorDetail: err.Error(),
-		}
-		return fmt.Errorf("failed to read response body: %w", err)
+		fe := classifyError(fmt.Errorf("failed to read response body: %w", err))
+		fe.StatusCode = resp.StatusCode
+		return nil, fe
 	}
 
-	// Create hash
-	hash := sha256.Sum256(body)
+	if pattern := matchSoft404(src, body); pattern != nil {
+		return nil, &fetchError{
+			StatusCode: resp.StatusCode,
+			Err:        fmt.Errorf("soft 404: HTTP %d body matched %q", resp.StatusCode, pattern.String()),
+		}
+	}
 
-	// Send successful update
-	out <- Update{
-		SourceID:   src.ID,
-		URL:        src.URL,
-		FetchedAt:  time.Now().UTC(),
-		Hash:       hex.EncodeToString(hash[:]),
-		Body:       body,
+	return &fetchResult{
 		StatusCode: resp.StatusCode,
-		Success:    true,
-		RetryCount: 0,
+		Header:     resp.Header,
+		Body:       body,
+	}, nil
+}
+
+// readBody reads the response body, aborting it if the body-read budget runs out
+func (h *HTTPScraper) readBody(resp *http.Response) ([]byte, error) {
+	if h.timeouts.BodyRead <= 0 {
+		return io.ReadAll(resp.Body)
 	}
 
-	log.Printf("[HTTP] Successfully scraped %s (status: %d, size: %d bytes)", src.URL, resp.StatusCode, len(body))
-	return nil
+	// Closing the body is the only way to unblock a stalled Read
+	var expired atomic.Bool
+	timer := time.AfterFunc(h.timeouts.BodyRead, func() {
+		expired.Store(true)
+		resp.Body.Close()
+	})
+	defer timer.Stop()
+
+	body, err := io.ReadAll(resp.Body)
+	if expired.Load() {
+		return nil, fmt.Errorf("body read exceeded %s: %w", h.timeouts.BodyRead, os.ErrDeadlineExceeded)
+	}
+	return body, err
 }
 
-// Scrape implements browser scraping (placeholder)
-func (b *BrowserScraper) Scrape(ctx context.Context, src Source, out chan<- Update) error {
-	log.Printf("[BROWSER] Browser scraping not implemented yet for %s", src.URL)
+// pageUpdate builds the update for a successfully fetched page of src
+func (h *HTTPScraper) pageUpdate(ctx context.Context, src Source, resp *fetchResult, attempts int) Update {
+	update := Update{
+		SourceID:     src.ID,
+		URL:          src.URL,
+		FetchedAt:    time.Now().UTC(),
+		Body:         resp.Body,
+		StatusCode:   resp.StatusCode,
+		Success:      true,
+		RetryCount:   attempts - 1,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		ContentType:  contentType(resp.Header, resp.Body),
+	}
+	describeBody(src, &update)
+	update.Documents = h.FetchAttachments(ctx, src, resp.Body)
+	return update
+}
+
+// describeBody fills in the hashes, title, text and items of a successful
+// update from its body. Documents such as PDFs are hashed over their
+// extracted text, so re-encoding a file without changing its text is not a change.
+func describeBody(src Source, update *Update) {
+	if isPDF(update.ContentType, update.Body) {
+		text, err := extractPDFText(update.Body)
+		if err == nil {
+			update.Text = text.Text()
+			update.TextHash = sha256Hex([]byte(update.Text))
+			update.Title = text.Title
+			update.Hash, _ = contentHashes(src, []byte(update.Text))
+			update.BodyHash = sha256Hex(update.Body)
+			return
+		}
+		log.Printf("[DOCUMENT] %s: %v; hashing the raw file", src.ID, err)
+
+		// A document without text has no title or items either, unless it
+		// was an HTML page served with the wrong type
+		if !isHTML(update.Body) {
+			update.BodyHash = sha256Hex(update.Body)
+			update.Hash = update.BodyHash
+			return
+		}
+	}
+
+	update.Hash, update.BodyHash = contentHashes(src, update.Body)
+	update.Title = pageMetadata(update.Body).Title
+	update.Items = extractItems(src, update.Body)
+}
+
+// FetchAttachments downloads the documents linked from a page, up to the
+// source's per-run limit. Links never fetched come first; known files are
+// re-checked once their last check is older than the source's recheck
+// interval, with the validators they were stored with, so a file replaced
+// under the same URL is fetched again. Failed downloads are logged and tried
+// again on the next run.
+func (h *HTTPScraper) FetchAttachments(ctx context.Context, src Source, body []byte) []Document {
+	links := attachmentLinks(src, body)
+	if len(links) == 0 {
+		return nil
+	}
+
+	var fresh, recheck []string
+	known := make(map[string]*DocumentState)
+	for _, link := range links {
+		if h.documents == nil {
+			fresh = append(fresh, link)
+			continue
+		}
+
+		state, err := h.documents.GetDocumentState(ctx, src.ID, link)
+		if err != nil {
+			log.Printf("[DOCUMENT] Could not check %s: %v", link, err)
+			continue
+		}
+		switch {
+		case state == nil:
+			fresh = append(fresh, link)
+		case time.Since(state.CheckedAt) >= src.AttachmentRecheck:
+			known[link] = state
+			recheck = append(recheck, link)
+		}
+	}
+
+	due := append(fresh, recheck...)
+	if len(due) > src.MaxAttachments {
+		log.Printf("[DOCUMENT] %s: reached %d attachments this run; the rest wait for the next run", src.ID, src.MaxAttachments)
+		due = due[:src.MaxAttachments]
+	}
+
+	var docs []Document
+	for _, link := range due {
+		if ctx.Err() != nil {
+			break
+		}
+
+		doc, err := h.fetchAttachment(ctx, src, link, known[link])
+		if err != nil {
+			log.Printf("[DOCUMENT] Failed to fetch %s: %v", link, err)
+			continue
+		}
+		docs = append(docs, doc)
+	}
+
+	return docs
+}
+
+// fetchAttachment downloads one attachment of src. A known file is requested
+// conditionally; when the server confirms it is current, the document is
+// returned marked NotModified, without a body.
+func (h *HTTPScraper) fetchAttachment(ctx context.Context, src Source, link string, state *DocumentState) (Document, error) {
+	// Soft-404 patterns describe the page, not the files it links to
+	attachment := src
+	attachment.Soft404Patterns = nil
 
-	// For now, just send an error update
-	out <- Update{
+	var header http.Header
+	if state != nil {
+		header = validatorHeaders(state.ETag, state.LastModified)
+	}
+
+	var resp *fetchResult
+	_, err := retry(ctx, link, src.MaxRetries, h.backoff, func(attempt int) error {
+		r, err := h.fetch(ctx, attachment, link, header)
+		if err != nil {
+			return err
+		}
+		resp = r
+		return nil
+	})
+	if err != nil {
+		return Document{}, err
+	}
+
+	etag := resp.Header.Get("ETag")
+	lastModified := resp.Header.Get("Last-Modified")
+
+	if resp.StatusCode == http.StatusNotModified && header != nil {
+		if etag == "" {
+			etag = state.ETag
+		}
+		if lastModified == "" {
+			lastModified = state.LastModified
+		}
+		log.Printf("[DOCUMENT] Not modified since last check: %s", link)
+		return Document{URL: link, ETag: etag, LastModified: lastModified, NotModified: true}, nil
+	}
+
+	doc := newDocument(link, contentType(resp.Header, resp.Body), resp.Body)
+	doc.ETag = etag
+	doc.LastModified = lastModified
+	log.Printf("[DOCUMENT] Fetched %s (%s, %d bytes, %d pages)", link, doc.ContentType, len(doc.Body), doc.Pages)
+	return doc, nil
+}
+
+// failedUpdate builds the update reported when all attempts for src failed
+func failedUpdate(src Source, attempts int, err error) Update {
+	update := Update{
 		SourceID:    src.ID,
 		URL:         src.URL,
 		FetchedAt:   time.Now().UTC(),
 		Success:     false,
-		ErrorDetail: "Browser scraping not implemented yet",
+		RetryCount:  attempts - 1,
+		ErrorDetail: err.Error(),
+
+		BlockedByRobots: errors.Is(err, ErrBlockedByRobots),
+		Cancelled:       errors.Is(err, context.Canceled),
+	}
+	var fe *fetchError
+	if errors.As(err, &fe) {
+		update.StatusCode = fe.StatusCode
+	}
+	return update
+}
+
+// Scrape implements browser scraping
+func (b *BrowserScraper) Scrape(ctx context.Context, src Source, out chan<- Update) error {
+	log.Printf("[BROWSER] Starting render of %s", src.URL)
+
+	var result *RenderResult
+	attempts, err := retry(ctx, src.ID, src.MaxRetries, b.backoff, func(attempt int) error {
+		r, err := b.render(ctx, src)
+		if err != nil {
+			return err
+		}
+		result = r
+		return nil
+	})
+	if err != nil {
+		out <- failedUpdate(src, attempts, err)
+		return fmt.Errorf("failed to render URL: %w", err)
+	}
+
+	update := Update{
+		SourceID:    src.ID,
+		URL:         src.URL,
+		FetchedAt:   time.Now().UTC(),
+		Body:        result.HTML,
+		StatusCode:  result.StatusCode,
+		Success:     true,
+		RetryCount:  attempts - 1,
+		ContentType: "text/html; charset=utf-8", // the serialized DOM, whatever was served
+	}
+	describeBody(src, &update)
+	if b.attachments != nil {
+		update.Documents = b.attachments.FetchAttachments(ctx, src, result.HTML)
+	}
+
+	out <- update
+
+	log.Printf("[BROWSER] Successfully rendered %s (status: %d, size: %d bytes, attempts: %d)",
+		src.URL, result.StatusCode, len(result.HTML), attempts)
+	return nil
+}
+
+// render renders src once within the run's deadline and applies the source's
+// response policy. Failures worth retrying are returned as *fetchError.
+func (b *BrowserScraper) render(ctx context.Context, src Source) (*RenderResult, error) {
+	if err := b.limiter.Wait(ctx, src.URL); err != nil {
+		return nil, err
+	}
+
+	result, err := b.renderer.Render(ctx, RenderRequest{
+		URL:             src.URL,
+		UserAgent:       b.userAgent,
+		WaitForSelector: src.WaitForSelector,
+		WaitNetworkIdle: src.WaitNetworkIdle,
+	})
+	if err != nil {
+		return nil, &fetchError{Retryable: isRetryableRenderError(err), Err: err}
+	}
+
+	// Not every backend can see the document's status
+	if result.StatusCode != 0 && !statusAccepted(src, result.StatusCode) {
+		return nil, newStatusError(result.StatusCode, "")
+	}
+
+	if pattern := matchSoft404(src, result.HTML); pattern != nil {
+		return nil, &fetchError{
+			StatusCode: result.StatusCode,
+			Err:        fmt.Errorf("soft 404: rendered page matched %q", pattern.String()),
+		}
 	}
 
-	return fmt.Errorf("browser scraping not implemented")
+	return result, nil
 }
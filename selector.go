@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/andybalholm/cascadia"
+	"github.com/antchfx/htmlquery"
+	"github.com/antchfx/xpath"
+	"golang.org/x/net/html"
+)
+
+// xpathPrefix marks a selector expression as XPath rather than CSS
+const xpathPrefix = "xpath:"
+
+// nodeSelector finds nodes within a parsed HTML tree
+type nodeSelector interface {
+	Select(root *html.Node) []*html.Node
+}
+
+// cssSelector matches nodes with a CSS selector group
+type cssSelector struct {
+	sel cascadia.Selector
+}
+
+func (c cssSelector) Select(root *html.Node) []*html.Node {
+	return c.sel.MatchAll(root)
+}
+
+// xpathSelector matches nodes with an XPath expression
+type xpathSelector struct {
+	expr *xpath.Expr
+}
+
+func (x xpathSelector) Select(root *html.Node) []*html.Node {
+	return htmlquery.QuerySelectorAll(root, x.expr)
+}
+
+// compileSelector compiles a selector expression. Expressions are CSS unless
+// prefixed with "xpath:".
+func compileSelector(expr string) (nodeSelector, error) {
+	if strings.HasPrefix(expr, xpathPrefix) {
+		compiled, err := xpath.Compile(strings.TrimSpace(strings.TrimPrefix(expr, xpathPrefix)))
+		if err != nil {
+			return nil, fmt.Errorf("invalid XPath %q: %w", expr, err)
+		}
+		return xpathSelector{expr: compiled}, nil
+	}
+
+	sel, err := cascadia.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSS selector %q: %w", expr, err)
+	}
+	return cssSelector{sel: sel}, nil
+}
+
+// compileSelectors compiles a list of selector expressions
+func compileSelectors(exprs []string) ([]nodeSelector, error) {
+	var selectors []nodeSelector
+	for _, expr := range exprs {
+		sel, err := compileSelector(expr)
+		if err != nil {
+			return nil, err
+		}
+		selectors = append(selectors, sel)
+	}
+	return selectors, nil
+}
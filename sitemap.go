@@ -0,0 +1,326 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	// SourceTypeSitemap discovers pages through a site's sitemap.xml
+	SourceTypeSitemap = "sitemap"
+
+	// defaultMaxSitemapFetches bounds how many pages a sitemap run fetches
+	defaultMaxSitemapFetches = 20
+	// maxChildSitemaps bounds how many sitemaps an index may pull in
+	maxChildSitemaps = 50
+	// maxSitemapSize is the protocol's limit on an uncompressed sitemap
+	maxSitemapSize = 50 << 20
+)
+
+// SitemapConfig controls which sitemap URLs are tracked and fetched
+type SitemapConfig struct {
+	Include          []string `yaml:"include"` // regexes a <loc> must match; empty tracks all
+	MaxFetchesPerRun int      `yaml:"max_fetches_per_run"`
+}
+
+// SitemapStore tracks the URLs listed in sitemaps and when they were fetched
+type SitemapStore interface {
+	GetSitemapURLs(ctx context.Context, sourceID string) (map[string]SitemapURL, error)
+	SaveSitemapURLs(ctx context.Context, sourceID string, urls []SitemapURL, seenAt time.Time) error
+	MarkSitemapURLFetched(ctx context.Context, sourceID, loc string, lastMod, fetchedAt time.Time) error
+}
+
+// SitemapScraper discovers pages through a sitemap and fetches those that
+// are new or whose lastmod advanced since they were last fetched
+type SitemapScraper struct {
+	http  *HTTPScraper
+	store SitemapStore
+}
+
+// NewSitemapScraper creates a sitemap scraper that fetches through http
+func NewSitemapScraper(http *HTTPScraper) *SitemapScraper {
+	return &SitemapScraper{http: http}
+}
+
+// SetSitemapStore sets where discovered URLs are tracked. Without a store
+// every listed URL is fetched on every run, up to the per-run limit.
+func (s *SitemapScraper) SetSitemapStore(store SitemapStore) {
+	s.store = store
+}
+
+// Scrape reads src's sitemap and emits an update for each page it fetches.
+// A run that fetches no pages is recorded as a check. The sitemap is read
+// unconditionally: pages held back by the per-run limit or by a failed fetch
+// are picked up from it on the next run even if it has not changed.
+func (s *SitemapScraper) Scrape(ctx context.Context, src Source, out chan<- Update) error {
+	log.Printf("[SITEMAP] Reading %s", src.URL)
+
+	resp, attempts, err := s.fetch(ctx, src, src.URL, nil)
+	if err != nil {
+		out <- failedUpdate(src, attempts, err)
+		return fmt.Errorf("failed to fetch sitemap: %w", err)
+	}
+
+	checked := Update{
+		SourceID:     src.ID,
+		URL:          src.URL,
+		FetchedAt:    time.Now().UTC(),
+		StatusCode:   resp.StatusCode,
+		Success:      true,
+		RetryCount:   attempts - 1,
+		NotModified:  true,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}
+
+	listed, err := s.collect(ctx, src, resp.Body)
+	if err != nil {
+		err = &fetchError{StatusCode: resp.StatusCode, Err: err}
+		out <- failedUpdate(src, attempts, err)
+		return err
+	}
+
+	due, err := s.dueURLs(ctx, src, listed)
+	if err != nil {
+		out <- failedUpdate(src, attempts, err)
+		return err
+	}
+
+	fetched := 0
+	for _, entry := range due {
+		if ctx.Err() != nil {
+			break
+		}
+		if fetched >= src.MaxSitemapFetches {
+			log.Printf("[SITEMAP] %s: reached %d pages this run; %d wait for the next run",
+				src.ID, src.MaxSitemapFetches, len(due)-fetched)
+			break
+		}
+		fetched++
+
+		page := src
+		page.URL = entry.Loc
+
+		pageResp, pageAttempts, err := s.fetch(ctx, page, entry.Loc, nil)
+		if err != nil {
+			log.Printf("[SITEMAP] Failed to fetch %s: %v", entry.Loc, err)
+			out <- failedUpdate(page, pageAttempts, err)
+			continue
+		}
+
+		// Validators belong to the sitemap, which is what the source's state tracks
+		update := s.http.pageUpdate(ctx, page, pageResp, pageAttempts)
+		update.ETag = checked.ETag
+		update.LastModified = checked.LastModified
+		out <- update
+
+		if s.store != nil {
+			if err := s.store.MarkSitemapURLFetched(ctx, src.ID, entry.Loc, entry.LastMod, update.FetchedAt); err != nil {
+				log.Printf("[SITEMAP] Failed to record fetch of %s: %v", entry.Loc, err)
+			}
+		}
+	}
+
+	if fetched == 0 {
+		out <- checked
+	}
+
+	log.Printf("[SITEMAP] Read %s (%d URLs, %d due, %d fetched)", src.URL, len(listed), len(due), fetched)
+	return nil
+}
+
+// fetch performs a GET with the source's retry policy
+func (s *SitemapScraper) fetch(ctx context.Context, src Source, target string, header http.Header) (*fetchResult, int, error) {
+	var resp *fetchResult
+	attempts, err := retry(ctx, src.ID, src.MaxRetries, s.http.backoff, func(attempt int) error {
+		r, err := s.http.fetch(ctx, src, target, header)
+		if err != nil {
+			return err
+		}
+		resp = r
+		return nil
+	})
+	return resp, attempts, err
+}
+
+// collect parses a sitemap, following a sitemap index one level down to the
+// sitemaps it lists, and returns the listed pages that match src's filters
+func (s *SitemapScraper) collect(ctx context.Context, src Source, body []byte) ([]SitemapURL, error) {
+	doc, err := parseSitemap(body, src.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	urls := doc.URLs
+	children := doc.Sitemaps
+	if len(children) > maxChildSitemaps {
+		log.Printf("[SITEMAP] %s: index lists %d sitemaps; reading the first %d", src.ID, len(children), maxChildSitemaps)
+		children = children[:maxChildSitemaps]
+	}
+
+	// Sitemap indexes may not nest, so children are read as plain sitemaps
+	for _, child := range children {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		resp, _, err := s.fetch(ctx, src, child.Loc, nil)
+		if err != nil {
+			log.Printf("[SITEMAP] Failed to fetch child sitemap %s: %v", child.Loc, err)
+			continue
+		}
+		childDoc, err := parseSitemap(resp.Body, child.Loc)
+		if err != nil {
+			log.Printf("[SITEMAP] Failed to parse child sitemap %s: %v", child.Loc, err)
+			continue
+		}
+		urls = append(urls, childDoc.URLs...)
+	}
+
+	var matched []SitemapURL
+	seen := make(map[string]bool)
+	for _, u := range urls {
+		if seen[u.Loc] || !sitemapIncluded(src.SitemapInclude, u.Loc) {
+			continue
+		}
+		seen[u.Loc] = true
+		matched = append(matched, u)
+	}
+	return matched, nil
+}
+
+// dueURLs records the listed URLs and returns those that were never fetched
+// or whose lastmod advanced since their last fetch, in sitemap order
+func (s *SitemapScraper) dueURLs(ctx context.Context, src Source, listed []SitemapURL) ([]SitemapURL, error) {
+	if s.store == nil {
+		return listed, nil
+	}
+
+	known, err := s.store.GetSitemapURLs(ctx, src.ID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.store.SaveSitemapURLs(ctx, src.ID, listed, time.Now().UTC()); err != nil {
+		return nil, err
+	}
+
+	var due []SitemapURL
+	for _, u := range listed {
+		prev, ok := known[u.Loc]
+		if !ok || prev.FetchedAt.IsZero() || u.LastMod.After(prev.FetchedLastMod) {
+			due = append(due, u)
+		}
+	}
+	return due, nil
+}
+
+// sitemapIncluded reports whether loc matches one of the include patterns
+func sitemapIncluded(include []*regexp.Regexp, loc string) bool {
+	return len(include) == 0 || matchesAny(include, loc)
+}
+
+// sitemapDocument is a parsed <urlset> or <sitemapindex>
+type sitemapDocument struct {
+	URLs     []SitemapURL
+	Sitemaps []SitemapURL
+}
+
+type sitemapXML struct {
+	XMLName  xml.Name
+	URLs     []sitemapEntry `xml:"url"`
+	Sitemaps []sitemapEntry `xml:"sitemap"`
+}
+
+type sitemapEntry struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod"`
+}
+
+// parseSitemap parses a sitemap or sitemap index, gzipped or not. Relative
+// locations are resolved against sitemapURL.
+func parseSitemap(body []byte, sitemapURL string) (*sitemapDocument, error) {
+	body, err := gunzipSitemap(body)
+	if err != nil {
+		return nil, err
+	}
+
+	var parsed sitemapXML
+	if err := newFeedDecoder(body).Decode(&parsed); err != nil {
+		return nil, fmt.Errorf("failed to parse sitemap: %w", err)
+	}
+	if parsed.XMLName.Local != "urlset" && parsed.XMLName.Local != "sitemapindex" {
+		return nil, fmt.Errorf("not a sitemap: unexpected root element <%s>", parsed.XMLName.Local)
+	}
+
+	base, _ := url.Parse(sitemapURL)
+	convert := func(entries []sitemapEntry) []SitemapURL {
+		var urls []SitemapURL
+		for _, e := range entries {
+			loc := strings.TrimSpace(e.Loc)
+			if loc == "" {
+				continue
+			}
+			if ref, err := url.Parse(loc); err == nil && base != nil {
+				loc = base.ResolveReference(ref).String()
+			}
+			urls = append(urls, SitemapURL{Loc: loc, LastMod: parseLastMod(e.LastMod)})
+		}
+		return urls
+	}
+
+	return &sitemapDocument{
+		URLs:     convert(parsed.URLs),
+		Sitemaps: convert(parsed.Sitemaps),
+	}, nil
+}
+
+// gunzipSitemap decompresses a gzipped sitemap. Servers send .xml.gz both
+// as-is and with Content-Encoding, so the magic bytes decide.
+func gunzipSitemap(body []byte) ([]byte, error) {
+	if !bytes.HasPrefix(body, []byte{0x1f, 0x8b}) {
+		return body, nil
+	}
+
+	reader, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress sitemap: %w", err)
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(io.LimitReader(reader, maxSitemapSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress sitemap: %w", err)
+	}
+	if len(data) > maxSitemapSize {
+		return nil, fmt.Errorf("sitemap exceeds %d bytes uncompressed", maxSitemapSize)
+	}
+	return data, nil
+}
+
+// lastModLayouts are the W3C datetime forms allowed in <lastmod>
+var lastModLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02T15:04:05",
+	"2006-01-02",
+}
+
+// parseLastMod parses a <lastmod> value, returning the zero time if absent or invalid
+func parseLastMod(s string) time.Time {
+	s = strings.TrimSpace(s)
+	for _, layout := range lastModLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.UTC()
+		}
+	}
+	return time.Time{}
+}
@@ -2,9 +2,14 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
+	"os"
+	"strings"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
@@ -12,97 +17,134 @@ import (
 
 // Storage defines the interface for persisting updates
 type Storage interface {
-	SaveUpdate(ctx context.Context, update Update) error
+	SaveUpdate(ctx context.Context, update Update, outbox ...OutboxEntry) error
 	GetLatestUpdateBySource(ctx context.Context, sourceID string) (*Update, error)
-	GetUpdateByHash(ctx context.Context, hash string) (*Update, bool, error)
+	GetUpdatesBySource(ctx context.Context, sourceID string, limit int) ([]Update, error)
+	GetPreviousUpdate(ctx context.Context, sourceID, url string, before time.Time) (*Update, error)
+	GetLatestUpdateByURL(ctx context.Context, sourceID, url string) (*Update, error)
+	GetUpdateByHash(ctx context.Context, sourceID, url, hash string) (*Update, bool, error)
 	GetUpdatesByDateRange(ctx context.Context, startDate, endDate time.Time) ([]Update, error)
+	GetLatestUpdateID(ctx context.Context) (int64, error)
+	GetUpdateIDAt(ctx context.Context, at time.Time) (int64, error)
+	GetUpdatesAfterID(ctx context.Context, afterID, throughID int64) ([]Update, error)
 	GetDailyStats(ctx context.Context, date time.Time) (map[string]interface{}, error)
 	GetSourceStats(ctx context.Context, date time.Time) (map[string]map[string]interface{}, error)
+	GetSourceState(ctx context.Context, sourceID string) (*SourceState, error)
+	RecordCheck(ctx context.Context, update Update, changed bool) error
+	RecordSkippedRun(ctx context.Context, sourceID string, at time.Time) error
+	SaveScrapeRun(ctx context.Context, run ScrapeRun) error
+	SaveDeadLetter(ctx context.Context, letter DeadLetter) error
+	DueOutboxEntries(ctx context.Context, channels []string, now time.Time, limit int) ([]OutboxEntry, error)
+	MarkOutboxSent(ctx context.Context, id int64, at time.Time) error
+	RecordOutboxFailure(ctx context.Context, id int64, detail string, next time.Time, dead bool) error
+	RetryOutbox(ctx context.Context, channel string, eventIDs []string, now time.Time) (int64, error)
+	GetDigestCursor(ctx context.Context, recipient, mode string) (*DigestCursor, error)
+	SaveDigestCursor(ctx context.Context, cursor DigestCursor) error
+	GetScrapeRunsBySource(ctx context.Context, sourceID string, limit int) ([]ScrapeRun, error)
+	GetScrapeRunsByDateRange(ctx context.Context, startDate, endDate time.Time) ([]ScrapeRun, error)
+	GetBody(ctx context.Context, hash string) ([]byte, error)
+	SaveItems(ctx context.Context, sourceID string, items []Item, seenAt time.Time) ([]Item, error)
+	GetItemsByDateRange(ctx context.Context, startDate, endDate time.Time) ([]Item, error)
+	HasItem(ctx context.Context, sourceID, itemID string) (bool, error)
+	SaveDocuments(ctx context.Context, update Update) ([]Document, error)
+	GetDocumentState(ctx context.Context, sourceID, url string) (*DocumentState, error)
+	GetSitemapURLs(ctx context.Context, sourceID string) (map[string]SitemapURL, error)
+	SaveSitemapURLs(ctx context.Context, sourceID string, urls []SitemapURL, seenAt time.Time) error
+	MarkSitemapURLFetched(ctx context.Context, sourceID, loc string, lastMod, fetchedAt time.Time) error
+	PendingCrawlURLs(ctx context.Context, sourceID string, limit int) ([]CrawlURL, error)
+	QueueCrawlURLs(ctx context.Context, sourceID string, urls []CrawlURL) error
+	MarkCrawlURLVisited(ctx context.Context, sourceID, url string, visitedAt time.Time) error
+	ResetCrawl(ctx context.Context, sourceID string) error
 	Close() error
 }
 
-// SQLiteStorage implements Storage using SQLite
+// SQLiteStorage implements Storage using SQLite, keeping fetched bodies in a blob store
 type SQLiteStorage struct {
-	db *sql.DB
+	db    *sql.DB
+	blobs BlobStore
 }
 
 // NewSQLiteStorage creates a new SQLite storage
-func NewSQLiteStorage(dbPath string) (*SQLiteStorage, error) {
+func NewSQLiteStorage(dbPath string, blobs BlobStore) (*SQLiteStorage, error) {
 	db, err := sql.Open("sqlite3", dbPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
 
-	if err := initSchema(db); err != nil {
+	migrator, err := NewMigrator(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	applied, err := migrator.Migrate(context.Background())
+	if err != nil {
 		db.Close()
-		return nil, fmt.Errorf("failed to initialize schema: %w", err)
+		return nil, fmt.Errorf("failed to migrate schema: %w", err)
+	}
+	for _, m := range applied {
+		log.Printf("[STORAGE] Applied migration %s", m)
 	}
 
 	log.Printf("[STORAGE] Database initialized at %s", dbPath)
-	return &SQLiteStorage{db: db}, nil
-}
-
-// initSchema creates the necessary tables
-func initSchema(db *sql.DB) error {
-	schema := `
-	CREATE TABLE IF NOT EXISTS updates (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		source_id TEXT NOT NULL,
-		url TEXT NOT NULL,
-		fetched_at TIMESTAMP NOT NULL,
-		hash TEXT UNIQUE,
-		status_code INTEGER NOT NULL,
-		success BOOLEAN NOT NULL,
-		retry_count INTEGER NOT NULL,
-		error_detail TEXT,
-		body_size INTEGER DEFAULT 0,
-		title TEXT,
-		summary TEXT,
-		content_type TEXT,
-		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
-	);
-	
-	CREATE INDEX IF NOT EXISTS idx_updates_source_id ON updates(source_id);
-	CREATE INDEX IF NOT EXISTS idx_updates_hash ON updates(hash);
-	CREATE INDEX IF NOT EXISTS idx_updates_fetched_at ON updates(fetched_at);
-	CREATE INDEX IF NOT EXISTS idx_updates_date ON updates(date(fetched_at));
-	`
-
-	_, err := db.Exec(schema)
-	return err
-}
-
-// SaveUpdate stores an update in the database
-func (s *SQLiteStorage) SaveUpdate(ctx context.Context, update Update) error {
-	// Check if we already have this hash
-	if update.Hash != "" {
-		_, exists, err := s.GetUpdateByHash(ctx, update.Hash)
-		if err != nil {
-			return fmt.Errorf("failed to check existing hash: %w", err)
+	return &SQLiteStorage{db: db, blobs: blobs}, nil
+}
+
+// nullIfEmpty maps an empty string to NULL so UNIQUE columns allow many blanks
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// SaveUpdate stores an update in the database. Callers decide whether it is
+// a change: the same content is stored again whenever it is passed in. The
+// outbox entries announcing it are written in the same transaction, so they
+// exist exactly when the update does.
+func (s *SQLiteStorage) SaveUpdate(ctx context.Context, update Update, outbox ...OutboxEntry) error {
+	// Keep the body itself so changes can be shown and proven later
+	bodyHash := update.BodyHash
+	if update.Success && len(update.Body) > 0 {
+		if bodyHash == "" {
+			sum := sha256.Sum256(update.Body)
+			bodyHash = hex.EncodeToString(sum[:])
+		}
+		if err := s.blobs.Put(bodyHash, update.Body); err != nil {
+			return fmt.Errorf("failed to store body: %w", err)
 		}
+	}
 
-		if exists {
-			// Update already exists, skip insertion
-			log.Printf("[STORAGE] Skipping duplicate update for hash %s (source: %s)",
-				update.Hash[:8], update.SourceID)
-			return nil
+	// Text extracted from documents is kept alongside the original file
+	textHash := update.TextHash
+	if update.Success && update.Text != "" {
+		if textHash == "" {
+			textHash = sha256Hex([]byte(update.Text))
+		}
+		if err := s.blobs.Put(textHash, []byte(update.Text)); err != nil {
+			return fmt.Errorf("failed to store text: %w", err)
 		}
 	}
 
 	query := `
 	INSERT INTO updates 
-	(source_id, url, fetched_at, hash, status_code, success, retry_count, error_detail, body_size, title, summary, content_type)
-	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	(source_id, url, fetched_at, hash, status_code, success, retry_count, error_detail, body_size, title, summary, content_type, body_hash, text_hash, blocked_by_robots, reverted)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`
 
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
 	bodySize := len(update.Body)
-	_, err := s.db.ExecContext(
+	_, err = tx.ExecContext(
 		ctx,
 		query,
 		update.SourceID,
 		update.URL,
 		update.FetchedAt.Format(time.RFC3339),
-		update.Hash,
+		nullIfEmpty(update.Hash),
 		update.StatusCode,
 		update.Success,
 		update.RetryCount,
@@ -111,12 +153,41 @@ func (s *SQLiteStorage) SaveUpdate(ctx context.Context, update Update) error {
 		update.Title,
 		update.Summary,
 		update.ContentType,
+		nullIfEmpty(bodyHash),
+		nullIfEmpty(textHash),
+		update.BlockedByRobots,
+		update.Reverted,
 	)
 
 	if err != nil {
 		return fmt.Errorf("failed to save update: %w", err)
 	}
 
+	// An event already queued for a channel is not queued twice
+	for _, entry := range outbox {
+		_, err := tx.ExecContext(ctx, `
+		INSERT OR IGNORE INTO outbox
+		(event_id, event, channel, source_id, payload, status, next_attempt_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		`,
+			entry.EventID,
+			entry.Event,
+			entry.Channel,
+			entry.SourceID,
+			string(entry.Payload),
+			OutboxPending,
+			entry.CreatedAt.UTC().Format(time.RFC3339),
+			entry.CreatedAt.UTC().Format(time.RFC3339),
+		)
+		if err != nil {
+			return fmt.Errorf("failed to queue %s notification: %w", entry.Channel, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to save update: %w", err)
+	}
+
 	return nil
 }
 
@@ -124,7 +195,7 @@ func (s *SQLiteStorage) SaveUpdate(ctx context.Context, update Update) error {
 func (s *SQLiteStorage) GetLatestUpdateBySource(ctx context.Context, sourceID string) (*Update, error) {
 	query := `
 	SELECT source_id, url, fetched_at, COALESCE(hash, '') as hash, status_code, success, retry_count, 
-	       COALESCE(error_detail, '') as error_detail
+	       COALESCE(error_detail, '') as error_detail, COALESCE(body_hash, '') as body_hash, COALESCE(text_hash, '') as text_hash
 	FROM updates
 	WHERE source_id = ? AND success = 1
 	ORDER BY fetched_at DESC
@@ -145,6 +216,161 @@ func (s *SQLiteStorage) GetLatestUpdateBySource(ctx context.Context, sourceID st
 		&update.Success,
 		&update.RetryCount,
 		&update.ErrorDetail,
+		&update.BodyHash,
+		&update.TextHash,
+	)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to get latest update: %w", err)
+	}
+
+	update.FetchedAt, err = time.Parse(time.RFC3339, fetchedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse fetched_at: %w", err)
+	}
+
+	return &update, nil
+}
+
+// GetUpdatesBySource retrieves the successful updates of a source, newest
+// first. A limit of zero or less returns all of them.
+func (s *SQLiteStorage) GetUpdatesBySource(ctx context.Context, sourceID string, limit int) ([]Update, error) {
+	query := `
+	SELECT source_id, url, fetched_at, COALESCE(hash, '') as hash, status_code, success, retry_count, 
+	       COALESCE(error_detail, '') as error_detail, COALESCE(title, '') as title,
+	       COALESCE(summary, '') as summary, COALESCE(content_type, '') as content_type,
+	       COALESCE(body_hash, '') as body_hash, COALESCE(text_hash, '') as text_hash
+	FROM updates
+	WHERE source_id = ? AND success = 1
+	ORDER BY fetched_at DESC, id DESC
+	LIMIT ?
+	`
+
+	if limit <= 0 {
+		limit = -1 // SQLite treats a negative LIMIT as unbounded
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, sourceID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query updates: %w", err)
+	}
+	defer rows.Close()
+
+	var updates []Update
+	for rows.Next() {
+		var update Update
+		var fetchedAt string
+
+		err := rows.Scan(
+			&update.SourceID,
+			&update.URL,
+			&fetchedAt,
+			&update.Hash,
+			&update.StatusCode,
+			&update.Success,
+			&update.RetryCount,
+			&update.ErrorDetail,
+			&update.Title,
+			&update.Summary,
+			&update.ContentType,
+			&update.BodyHash,
+			&update.TextHash,
+		)
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan update: %w", err)
+		}
+
+		update.FetchedAt, err = time.Parse(time.RFC3339, fetchedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse fetched_at: %w", err)
+		}
+
+		updates = append(updates, update)
+	}
+
+	return updates, nil
+}
+
+// GetPreviousUpdate retrieves the latest successful update of a source's URL
+// fetched before a given time. Sources that discover pages have many URLs,
+// each with its own versions.
+func (s *SQLiteStorage) GetPreviousUpdate(ctx context.Context, sourceID, url string, before time.Time) (*Update, error) {
+	query := `
+	SELECT source_id, url, fetched_at, COALESCE(hash, '') as hash, status_code, success, retry_count, 
+	       COALESCE(error_detail, '') as error_detail, COALESCE(body_hash, '') as body_hash, COALESCE(text_hash, '') as text_hash
+	FROM updates
+	WHERE source_id = ? AND url = ? AND success = 1 AND fetched_at < ?
+	ORDER BY fetched_at DESC, id DESC
+	LIMIT 1
+	`
+
+	row := s.db.QueryRowContext(ctx, query, sourceID, url, before.UTC().Format(time.RFC3339))
+
+	var update Update
+	var fetchedAt string
+
+	err := row.Scan(
+		&update.SourceID,
+		&update.URL,
+		&fetchedAt,
+		&update.Hash,
+		&update.StatusCode,
+		&update.Success,
+		&update.RetryCount,
+		&update.ErrorDetail,
+		&update.BodyHash,
+		&update.TextHash,
+	)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to get previous update: %w", err)
+	}
+
+	update.FetchedAt, err = time.Parse(time.RFC3339, fetchedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse fetched_at: %w", err)
+	}
+
+	return &update, nil
+}
+
+// GetLatestUpdateByURL retrieves the latest successful update of a source's
+// URL, the version a new fetch of it is compared against
+func (s *SQLiteStorage) GetLatestUpdateByURL(ctx context.Context, sourceID, url string) (*Update, error) {
+	query := `
+	SELECT source_id, url, fetched_at, COALESCE(hash, '') as hash, status_code, success, retry_count, 
+	       COALESCE(error_detail, '') as error_detail, COALESCE(body_hash, '') as body_hash, COALESCE(text_hash, '') as text_hash
+	FROM updates
+	WHERE source_id = ? AND url = ? AND success = 1
+	ORDER BY fetched_at DESC, id DESC
+	LIMIT 1
+	`
+
+	row := s.db.QueryRowContext(ctx, query, sourceID, url)
+
+	var update Update
+	var fetchedAt string
+
+	err := row.Scan(
+		&update.SourceID,
+		&update.URL,
+		&fetchedAt,
+		&update.Hash,
+		&update.StatusCode,
+		&update.Success,
+		&update.RetryCount,
+		&update.ErrorDetail,
+		&update.BodyHash,
+		&update.TextHash,
 	)
 
 	if err == sql.ErrNoRows {
@@ -163,21 +389,23 @@ func (s *SQLiteStorage) GetLatestUpdateBySource(ctx context.Context, sourceID st
 	return &update, nil
 }
 
-// GetUpdateByHash retrieves an update by its hash
-func (s *SQLiteStorage) GetUpdateByHash(ctx context.Context, hash string) (*Update, bool, error) {
+// GetUpdateByHash retrieves the latest update of a source's URL with the
+// given hash, reporting whether that content was ever stored for it
+func (s *SQLiteStorage) GetUpdateByHash(ctx context.Context, sourceID, url, hash string) (*Update, bool, error) {
 	if hash == "" {
 		return nil, false, nil
 	}
 
 	query := `
 	SELECT source_id, url, fetched_at, hash, status_code, success, retry_count, 
-	       COALESCE(error_detail, '') as error_detail
+	       COALESCE(error_detail, '') as error_detail, COALESCE(body_hash, '') as body_hash, COALESCE(text_hash, '') as text_hash
 	FROM updates
-	WHERE hash = ?
+	WHERE source_id = ? AND url = ? AND hash = ?
+	ORDER BY fetched_at DESC, id DESC
 	LIMIT 1
 	`
 
-	row := s.db.QueryRowContext(ctx, query, hash)
+	row := s.db.QueryRowContext(ctx, query, sourceID, url, hash)
 
 	var update Update
 	var fetchedAt string
@@ -191,6 +419,8 @@ func (s *SQLiteStorage) GetUpdateByHash(ctx context.Context, hash string) (*Upda
 		&update.Success,
 		&update.RetryCount,
 		&update.ErrorDetail,
+		&update.BodyHash,
+		&update.TextHash,
 	)
 
 	if err == sql.ErrNoRows {
@@ -209,13 +439,18 @@ func (s *SQLiteStorage) GetUpdateByHash(ctx context.Context, hash string) (*Upda
 	return &update, true, nil
 }
 
-// GetUpdatesByDateRange retrieves all updates within a date range
-func (s *SQLiteStorage) GetUpdatesByDateRange(ctx context.Context, startDate, endDate time.Time) ([]Update, error) {
-	query := `
+// updateRangeColumns are the columns read by queries over a range of updates
+const updateRangeColumns = `
 	SELECT source_id, url, fetched_at, COALESCE(hash, '') as hash, status_code, success, retry_count, 
 	       COALESCE(error_detail, '') as error_detail, COALESCE(title, '') as title, 
-	       COALESCE(summary, '') as summary, COALESCE(content_type, '') as content_type
-	FROM updates
+	       COALESCE(summary, '') as summary, COALESCE(content_type, '') as content_type,
+	       COALESCE(body_hash, '') as body_hash, COALESCE(text_hash, '') as text_hash,
+	       blocked_by_robots, reverted
+	FROM updates`
+
+// GetUpdatesByDateRange retrieves all updates within a date range
+func (s *SQLiteStorage) GetUpdatesByDateRange(ctx context.Context, startDate, endDate time.Time) ([]Update, error) {
+	query := updateRangeColumns + `
 	WHERE date(fetched_at) >= date(?) AND date(fetched_at) <= date(?)
 	ORDER BY fetched_at DESC
 	`
@@ -226,6 +461,51 @@ func (s *SQLiteStorage) GetUpdatesByDateRange(ctx context.Context, startDate, en
 	}
 	defer rows.Close()
 
+	return scanUpdateRange(rows)
+}
+
+// GetLatestUpdateID returns the ID of the last update saved, zero if none
+func (s *SQLiteStorage) GetLatestUpdateID(ctx context.Context) (int64, error) {
+	var id int64
+	if err := s.db.QueryRowContext(ctx, `SELECT COALESCE(MAX(id), 0) FROM updates`).Scan(&id); err != nil {
+		return 0, fmt.Errorf("failed to get latest update: %w", err)
+	}
+	return id, nil
+}
+
+// GetUpdateIDAt returns the ID of the last update fetched at or before at,
+// zero if none
+func (s *SQLiteStorage) GetUpdateIDAt(ctx context.Context, at time.Time) (int64, error) {
+	var id int64
+	err := s.db.QueryRowContext(ctx,
+		`SELECT COALESCE(MAX(id), 0) FROM updates WHERE julianday(fetched_at) <= julianday(?)`,
+		at.UTC().Format(time.RFC3339),
+	).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to find update at %s: %w", at.Format(time.RFC3339), err)
+	}
+	return id, nil
+}
+
+// GetUpdatesAfterID retrieves the updates saved after afterID, up to and
+// including throughID, in the order they were saved
+func (s *SQLiteStorage) GetUpdatesAfterID(ctx context.Context, afterID, throughID int64) ([]Update, error) {
+	query := updateRangeColumns + `
+	WHERE id > ? AND id <= ?
+	ORDER BY id
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, afterID, throughID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query updates: %w", err)
+	}
+	defer rows.Close()
+
+	return scanUpdateRange(rows)
+}
+
+// scanUpdateRange reads the rows of a query selecting updateRangeColumns
+func scanUpdateRange(rows *sql.Rows) ([]Update, error) {
 	var updates []Update
 	for rows.Next() {
 		var update Update
@@ -243,6 +523,10 @@ func (s *SQLiteStorage) GetUpdatesByDateRange(ctx context.Context, startDate, en
 			&update.Title,
 			&update.Summary,
 			&update.ContentType,
+			&update.BodyHash,
+			&update.TextHash,
+			&update.BlockedByRobots,
+			&update.Reverted,
 		)
 
 		if err != nil {
@@ -338,6 +622,896 @@ func (s *SQLiteStorage) GetSourceStats(ctx context.Context, date time.Time) (map
 	return stats, nil
 }
 
+// GetSourceState retrieves the cache validators and check times for a source
+func (s *SQLiteStorage) GetSourceState(ctx context.Context, sourceID string) (*SourceState, error) {
+	query := `
+	SELECT source_id, COALESCE(etag, ''), COALESCE(last_modified, ''),
+	       COALESCE(last_checked_at, ''), COALESCE(last_changed_at, ''),
+	       skipped_runs, COALESCE(last_skipped_at, '')
+	FROM source_state
+	WHERE source_id = ?
+	`
+
+	var state SourceState
+	var checkedAt, changedAt, skippedAt string
+
+	err := s.db.QueryRowContext(ctx, query, sourceID).Scan(
+		&state.SourceID,
+		&state.ETag,
+		&state.LastModified,
+		&checkedAt,
+		&changedAt,
+		&state.SkippedRuns,
+		&skippedAt,
+	)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to get source state: %w", err)
+	}
+
+	if checkedAt != "" {
+		if state.LastCheckedAt, err = time.Parse(time.RFC3339, checkedAt); err != nil {
+			return nil, fmt.Errorf("failed to parse last_checked_at: %w", err)
+		}
+	}
+	if changedAt != "" {
+		if state.LastChangedAt, err = time.Parse(time.RFC3339, changedAt); err != nil {
+			return nil, fmt.Errorf("failed to parse last_changed_at: %w", err)
+		}
+	}
+	if skippedAt != "" {
+		if state.LastSkippedAt, err = time.Parse(time.RFC3339, skippedAt); err != nil {
+			return nil, fmt.Errorf("failed to parse last_skipped_at: %w", err)
+		}
+	}
+
+	return &state, nil
+}
+
+// RecordCheck records a successful check of a source, remembering its cache
+// validators. changed marks the check as having found new content.
+func (s *SQLiteStorage) RecordCheck(ctx context.Context, update Update, changed bool) error {
+	query := `
+	INSERT INTO source_state
+	(source_id, etag, last_modified, last_checked_at, last_changed_at, last_status_code)
+	VALUES (?, ?, ?, ?, ?, ?)
+	ON CONFLICT(source_id) DO UPDATE SET
+		etag = excluded.etag,
+		last_modified = excluded.last_modified,
+		last_checked_at = excluded.last_checked_at,
+		last_changed_at = COALESCE(excluded.last_changed_at, source_state.last_changed_at),
+		last_status_code = excluded.last_status_code
+	`
+
+	fetchedAt := update.FetchedAt.Format(time.RFC3339)
+	var changedAt interface{}
+	if changed {
+		changedAt = fetchedAt
+	}
+
+	_, err := s.db.ExecContext(
+		ctx,
+		query,
+		update.SourceID,
+		update.ETag,
+		update.LastModified,
+		fetchedAt,
+		changedAt,
+		update.StatusCode,
+	)
+
+	if err != nil {
+		return fmt.Errorf("failed to record check: %w", err)
+	}
+
+	return nil
+}
+
+// RecordSkippedRun counts a scheduled run of a source that did not happen
+// because an earlier run was still going or the scrape queue was full
+func (s *SQLiteStorage) RecordSkippedRun(ctx context.Context, sourceID string, at time.Time) error {
+	query := `
+	INSERT INTO source_state (source_id, skipped_runs, last_skipped_at)
+	VALUES (?, 1, ?)
+	ON CONFLICT(source_id) DO UPDATE SET
+		skipped_runs = source_state.skipped_runs + 1,
+		last_skipped_at = excluded.last_skipped_at
+	`
+
+	if _, err := s.db.ExecContext(ctx, query, sourceID, at.Format(time.RFC3339)); err != nil {
+		return fmt.Errorf("failed to record skipped run: %w", err)
+	}
+
+	return nil
+}
+
+// SaveScrapeRun records a fetch of a source, whatever its outcome
+func (s *SQLiteStorage) SaveScrapeRun(ctx context.Context, run ScrapeRun) error {
+	query := `
+	INSERT INTO scrape_runs
+	(source_id, url, started_at, finished_at, duration_ms, status_code, bytes, outcome, attempts, error_detail)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+
+	_, err := s.db.ExecContext(
+		ctx,
+		query,
+		run.SourceID,
+		run.URL,
+		run.StartedAt.UTC().Format(time.RFC3339),
+		run.FinishedAt.UTC().Format(time.RFC3339),
+		run.Duration.Milliseconds(),
+		run.StatusCode,
+		run.Bytes,
+		run.Outcome,
+		run.Attempts,
+		nullIfEmpty(run.Error),
+	)
+
+	if err != nil {
+		return fmt.Errorf("failed to save scrape run: %w", err)
+	}
+
+	return nil
+}
+
+// SaveDeadLetter keeps a notification a channel gave up delivering
+func (s *SQLiteStorage) SaveDeadLetter(ctx context.Context, letter DeadLetter) error {
+	query := `
+	INSERT INTO dead_letters
+	(channel, event_id, event, source_id, target, payload, attempts, last_status_code, error_detail, failed_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+
+	_, err := s.db.ExecContext(
+		ctx,
+		query,
+		letter.Channel,
+		letter.EventID,
+		letter.Event,
+		letter.SourceID,
+		nullIfEmpty(letter.Target),
+		string(letter.Payload),
+		letter.Attempts,
+		letter.StatusCode,
+		nullIfEmpty(letter.Error),
+		letter.FailedAt.UTC().Format(time.RFC3339),
+	)
+
+	if err != nil {
+		return fmt.Errorf("failed to save dead letter: %w", err)
+	}
+
+	return nil
+}
+
+// DueOutboxEntries retrieves pending entries for the given channels whose
+// next attempt is due, oldest first
+func (s *SQLiteStorage) DueOutboxEntries(ctx context.Context, channels []string, now time.Time, limit int) ([]OutboxEntry, error) {
+	if len(channels) == 0 {
+		return nil, nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(channels)), ", ")
+	query := `
+	SELECT id, event_id, event, channel, source_id, payload, status, attempts,
+	       next_attempt_at, COALESCE(last_error, ''), created_at
+	FROM outbox
+	WHERE status = ? AND next_attempt_at <= ? AND channel IN (` + placeholders + `)
+	ORDER BY next_attempt_at, id
+	LIMIT ?
+	`
+
+	args := []interface{}{OutboxPending, now.UTC().Format(time.RFC3339)}
+	for _, channel := range channels {
+		args = append(args, channel)
+	}
+	args = append(args, limit)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query outbox: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []OutboxEntry
+	for rows.Next() {
+		var entry OutboxEntry
+		var payload, nextAttemptAt, createdAt string
+		err := rows.Scan(
+			&entry.ID,
+			&entry.EventID,
+			&entry.Event,
+			&entry.Channel,
+			&entry.SourceID,
+			&payload,
+			&entry.Status,
+			&entry.Attempts,
+			&nextAttemptAt,
+			&entry.LastError,
+			&createdAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan outbox entry: %w", err)
+		}
+
+		entry.Payload = []byte(payload)
+		if entry.NextAttemptAt, err = time.Parse(time.RFC3339, nextAttemptAt); err != nil {
+			return nil, fmt.Errorf("failed to parse next_attempt_at: %w", err)
+		}
+		if entry.CreatedAt, err = time.Parse(time.RFC3339, createdAt); err != nil {
+			return nil, fmt.Errorf("failed to parse created_at: %w", err)
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// MarkOutboxSent records the delivery of an outbox entry
+func (s *SQLiteStorage) MarkOutboxSent(ctx context.Context, id int64, at time.Time) error {
+	query := `
+	UPDATE outbox SET status = ?, attempts = attempts + 1, sent_at = ?, last_error = NULL
+	WHERE id = ?
+	`
+
+	if _, err := s.db.ExecContext(ctx, query, OutboxSent, at.UTC().Format(time.RFC3339), id); err != nil {
+		return fmt.Errorf("failed to mark notification sent: %w", err)
+	}
+
+	return nil
+}
+
+// RecordOutboxFailure records a failed delivery attempt, scheduling the next
+// one at next, or giving up on the entry if dead is set
+func (s *SQLiteStorage) RecordOutboxFailure(ctx context.Context, id int64, detail string, next time.Time, dead bool) error {
+	status := OutboxPending
+	if dead {
+		status = OutboxDead
+	}
+
+	query := `
+	UPDATE outbox SET status = ?, attempts = attempts + 1, next_attempt_at = ?, last_error = ?
+	WHERE id = ?
+	`
+
+	if _, err := s.db.ExecContext(ctx, query, status, next.UTC().Format(time.RFC3339), detail, id); err != nil {
+		return fmt.Errorf("failed to record notification failure: %w", err)
+	}
+
+	return nil
+}
+
+// RetryOutbox makes dead entries pending again, due at now with a fresh
+// count of attempts. An empty channel matches every channel, and no event
+// IDs match every event. It returns the number of entries requeued.
+func (s *SQLiteStorage) RetryOutbox(ctx context.Context, channel string, eventIDs []string, now time.Time) (int64, error) {
+	query := `UPDATE outbox SET status = ?, attempts = 0, next_attempt_at = ? WHERE status = ?`
+	args := []interface{}{OutboxPending, now.UTC().Format(time.RFC3339), OutboxDead}
+
+	if channel != "" {
+		query += ` AND channel = ?`
+		args = append(args, channel)
+	}
+	if len(eventIDs) > 0 {
+		query += ` AND event_id IN (` + strings.TrimSuffix(strings.Repeat("?, ", len(eventIDs)), ", ") + `)`
+		for _, id := range eventIDs {
+			args = append(args, id)
+		}
+	}
+
+	result, err := s.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to requeue notifications: %w", err)
+	}
+
+	return result.RowsAffected()
+}
+
+// GetDigestCursor returns how far a recipient's digests in a mode have got,
+// nil if none has been sent
+func (s *SQLiteStorage) GetDigestCursor(ctx context.Context, recipient, mode string) (*DigestCursor, error) {
+	query := `
+	SELECT last_update_id, covered_at, COALESCE(last_sent_at, '')
+	FROM digest_state
+	WHERE recipient = ? AND mode = ?
+	`
+
+	cursor := DigestCursor{Recipient: recipient, Mode: mode}
+	var coveredAt, lastSentAt string
+	err := s.db.QueryRowContext(ctx, query, recipient, mode).Scan(&cursor.LastUpdateID, &coveredAt, &lastSentAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get digest state: %w", err)
+	}
+
+	cursor.CoveredAt, err = time.Parse(time.RFC3339, coveredAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse covered_at: %w", err)
+	}
+	if lastSentAt != "" {
+		cursor.LastSentAt, err = time.Parse(time.RFC3339, lastSentAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse last_sent_at: %w", err)
+		}
+	}
+	return &cursor, nil
+}
+
+// SaveDigestCursor records how far a recipient's digests in a mode have got
+func (s *SQLiteStorage) SaveDigestCursor(ctx context.Context, cursor DigestCursor) error {
+	query := `
+	INSERT INTO digest_state (recipient, mode, last_update_id, covered_at, last_sent_at)
+	VALUES (?, ?, ?, ?, ?)
+	ON CONFLICT(recipient, mode) DO UPDATE SET
+		last_update_id = excluded.last_update_id,
+		covered_at = excluded.covered_at,
+		last_sent_at = COALESCE(excluded.last_sent_at, digest_state.last_sent_at)
+	`
+
+	_, err := s.db.ExecContext(ctx, query,
+		cursor.Recipient,
+		cursor.Mode,
+		cursor.LastUpdateID,
+		cursor.CoveredAt.UTC().Format(time.RFC3339),
+		formatOptionalTime(cursor.LastSentAt),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save digest state: %w", err)
+	}
+
+	return nil
+}
+
+// GetScrapeRunsBySource retrieves the runs of a source, newest first. A limit
+// of zero or less returns all of them.
+func (s *SQLiteStorage) GetScrapeRunsBySource(ctx context.Context, sourceID string, limit int) ([]ScrapeRun, error) {
+	query := `
+	SELECT source_id, url, started_at, finished_at, duration_ms, COALESCE(status_code, 0),
+	       bytes, outcome, attempts, COALESCE(error_detail, '')
+	FROM scrape_runs
+	WHERE source_id = ?
+	ORDER BY started_at DESC, id DESC
+	LIMIT ?
+	`
+
+	if limit <= 0 {
+		limit = -1 // SQLite treats a negative LIMIT as unbounded
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, sourceID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query scrape runs: %w", err)
+	}
+	defer rows.Close()
+
+	return scanScrapeRuns(rows)
+}
+
+// GetScrapeRunsByDateRange retrieves the runs started within a date range,
+// grouped by source in the order they ran
+func (s *SQLiteStorage) GetScrapeRunsByDateRange(ctx context.Context, startDate, endDate time.Time) ([]ScrapeRun, error) {
+	query := `
+	SELECT source_id, url, started_at, finished_at, duration_ms, COALESCE(status_code, 0),
+	       bytes, outcome, attempts, COALESCE(error_detail, '')
+	FROM scrape_runs
+	WHERE date(started_at) >= date(?) AND date(started_at) <= date(?)
+	ORDER BY source_id, started_at, id
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, startDate.Format("2006-01-02"), endDate.Format("2006-01-02"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query scrape runs: %w", err)
+	}
+	defer rows.Close()
+
+	return scanScrapeRuns(rows)
+}
+
+// scanScrapeRuns reads the rows of a scrape_runs query
+func scanScrapeRuns(rows *sql.Rows) ([]ScrapeRun, error) {
+	var runs []ScrapeRun
+	for rows.Next() {
+		var run ScrapeRun
+		var startedAt, finishedAt string
+		var durationMS int64
+
+		err := rows.Scan(
+			&run.SourceID,
+			&run.URL,
+			&startedAt,
+			&finishedAt,
+			&durationMS,
+			&run.StatusCode,
+			&run.Bytes,
+			&run.Outcome,
+			&run.Attempts,
+			&run.Error,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan scrape run: %w", err)
+		}
+
+		if run.StartedAt, err = time.Parse(time.RFC3339, startedAt); err != nil {
+			return nil, fmt.Errorf("failed to parse started_at: %w", err)
+		}
+		if run.FinishedAt, err = time.Parse(time.RFC3339, finishedAt); err != nil {
+			return nil, fmt.Errorf("failed to parse finished_at: %w", err)
+		}
+		run.Duration = time.Duration(durationMS) * time.Millisecond
+
+		runs = append(runs, run)
+	}
+
+	return runs, rows.Err()
+}
+
+// GetBody retrieves a stored body by its content hash, or nil if it was never stored
+func (s *SQLiteStorage) GetBody(ctx context.Context, hash string) ([]byte, error) {
+	if hash == "" {
+		return nil, nil
+	}
+
+	body, err := s.blobs.Get(hash)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get body: %w", err)
+	}
+
+	return body, nil
+}
+
+// SaveItems records the items currently listed by a source and returns the
+// ones not seen before. Known items have their details and last_seen_at refreshed.
+func (s *SQLiteStorage) SaveItems(ctx context.Context, sourceID string, items []Item, seenAt time.Time) ([]Item, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	insert := `
+	INSERT INTO items
+	(source_id, item_id, title, link, reference, date_text, published_at, first_seen_at, last_seen_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT(source_id, item_id) DO NOTHING
+	`
+	refresh := `
+	UPDATE items
+	SET title = ?, link = ?, reference = ?, date_text = ?, published_at = ?, last_seen_at = ?
+	WHERE source_id = ? AND item_id = ?
+	`
+
+	seen := seenAt.UTC().Format(time.RFC3339)
+	var newItems []Item
+	for _, item := range items {
+		var publishedAt interface{}
+		if !item.PublishedAt.IsZero() {
+			publishedAt = item.PublishedAt.Format(time.RFC3339)
+		}
+
+		result, err := tx.ExecContext(ctx, insert,
+			sourceID, item.ItemID, item.Title, item.Link, item.Reference,
+			item.DateText, publishedAt, seen, seen)
+		if err != nil {
+			return nil, fmt.Errorf("failed to save item: %w", err)
+		}
+
+		inserted, err := result.RowsAffected()
+		if err != nil {
+			return nil, fmt.Errorf("failed to save item: %w", err)
+		}
+		if inserted > 0 {
+			item.SourceID = sourceID
+			item.FirstSeenAt = seenAt.UTC()
+			item.LastSeenAt = seenAt.UTC()
+			newItems = append(newItems, item)
+			continue
+		}
+
+		if _, err := tx.ExecContext(ctx, refresh,
+			item.Title, item.Link, item.Reference, item.DateText, publishedAt, seen,
+			sourceID, item.ItemID); err != nil {
+			return nil, fmt.Errorf("failed to refresh item: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit items: %w", err)
+	}
+
+	return newItems, nil
+}
+
+// GetItemsByDateRange retrieves the items first seen within a date range
+func (s *SQLiteStorage) GetItemsByDateRange(ctx context.Context, startDate, endDate time.Time) ([]Item, error) {
+	query := `
+	SELECT source_id, item_id, COALESCE(title, ''), COALESCE(link, ''), COALESCE(reference, ''),
+	       COALESCE(date_text, ''), COALESCE(published_at, ''), first_seen_at, last_seen_at
+	FROM items
+	WHERE date(first_seen_at) >= date(?) AND date(first_seen_at) <= date(?)
+	ORDER BY source_id, first_seen_at DESC, id
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, startDate.Format("2006-01-02"), endDate.Format("2006-01-02"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query items: %w", err)
+	}
+	defer rows.Close()
+
+	var items []Item
+	for rows.Next() {
+		var item Item
+		var publishedAt, firstSeenAt, lastSeenAt string
+
+		err := rows.Scan(
+			&item.SourceID,
+			&item.ItemID,
+			&item.Title,
+			&item.Link,
+			&item.Reference,
+			&item.DateText,
+			&publishedAt,
+			&firstSeenAt,
+			&lastSeenAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan item: %w", err)
+		}
+
+		if publishedAt != "" {
+			if item.PublishedAt, err = time.Parse(time.RFC3339, publishedAt); err != nil {
+				return nil, fmt.Errorf("failed to parse published_at: %w", err)
+			}
+		}
+		if item.FirstSeenAt, err = time.Parse(time.RFC3339, firstSeenAt); err != nil {
+			return nil, fmt.Errorf("failed to parse first_seen_at: %w", err)
+		}
+		if item.LastSeenAt, err = time.Parse(time.RFC3339, lastSeenAt); err != nil {
+			return nil, fmt.Errorf("failed to parse last_seen_at: %w", err)
+		}
+
+		items = append(items, item)
+	}
+
+	return items, rows.Err()
+}
+
+// HasItem reports whether an item of a source was already recorded
+func (s *SQLiteStorage) HasItem(ctx context.Context, sourceID, itemID string) (bool, error) {
+	var exists bool
+	err := s.db.QueryRowContext(ctx,
+		`SELECT EXISTS(SELECT 1 FROM items WHERE source_id = ? AND item_id = ?)`,
+		sourceID, itemID,
+	).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("failed to check item: %w", err)
+	}
+	return exists, nil
+}
+
+// SaveDocuments records the attachments fetched with an update, storing each
+// file and its extracted text in the blob store. It returns the documents not
+// stored before; a known file at a known URL is not recorded twice, only
+// marked checked, as is the version a not-modified re-check confirmed.
+func (s *SQLiteStorage) SaveDocuments(ctx context.Context, update Update) ([]Document, error) {
+	if len(update.Documents) == 0 {
+		return nil, nil
+	}
+
+	insert := `
+	INSERT INTO documents
+	(source_id, url, page_url, fetched_at, content_type, title, pages, body_size, body_hash, text_hash,
+	 etag, last_modified, checked_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT(source_id, url, body_hash) DO NOTHING
+	`
+	checked := `
+	UPDATE documents
+	SET checked_at = ?, etag = ?, last_modified = ?
+	WHERE source_id = ? AND url = ? AND body_hash = ?
+	`
+	notModified := `
+	UPDATE documents
+	SET checked_at = ?, etag = ?, last_modified = ?
+	WHERE id = (
+		SELECT id FROM documents
+		WHERE source_id = ? AND url = ?
+		ORDER BY julianday(COALESCE(checked_at, fetched_at)) DESC, id DESC
+		LIMIT 1
+	)
+	`
+	checkedAt := update.FetchedAt.Format(time.RFC3339)
+
+	var saved []Document
+	for _, doc := range update.Documents {
+		if doc.NotModified {
+			_, err := s.db.ExecContext(ctx, notModified,
+				checkedAt, nullIfEmpty(doc.ETag), nullIfEmpty(doc.LastModified),
+				update.SourceID, doc.URL,
+			)
+			if err != nil {
+				return saved, fmt.Errorf("failed to record check of document %s: %w", doc.URL, err)
+			}
+			continue
+		}
+
+		bodyHash := doc.BodyHash
+		if bodyHash == "" {
+			bodyHash = sha256Hex(doc.Body)
+		}
+		if err := s.blobs.Put(bodyHash, doc.Body); err != nil {
+			return saved, fmt.Errorf("failed to store document %s: %w", doc.URL, err)
+		}
+
+		textHash := doc.TextHash
+		if doc.Text != "" {
+			if textHash == "" {
+				textHash = sha256Hex([]byte(doc.Text))
+			}
+			if err := s.blobs.Put(textHash, []byte(doc.Text)); err != nil {
+				return saved, fmt.Errorf("failed to store text of %s: %w", doc.URL, err)
+			}
+		}
+
+		result, err := s.db.ExecContext(
+			ctx,
+			insert,
+			update.SourceID,
+			doc.URL,
+			update.URL,
+			update.FetchedAt.Format(time.RFC3339),
+			doc.ContentType,
+			doc.Title,
+			doc.Pages,
+			len(doc.Body),
+			bodyHash,
+			nullIfEmpty(textHash),
+			nullIfEmpty(doc.ETag),
+			nullIfEmpty(doc.LastModified),
+			checkedAt,
+		)
+		if err != nil {
+			return saved, fmt.Errorf("failed to save document %s: %w", doc.URL, err)
+		}
+
+		if inserted, err := result.RowsAffected(); err == nil && inserted > 0 {
+			saved = append(saved, doc)
+			continue
+		}
+
+		// A version stored before, fetched again without validators or
+		// after the file was put back; it is the current version again
+		_, err = s.db.ExecContext(ctx, checked,
+			checkedAt, nullIfEmpty(doc.ETag), nullIfEmpty(doc.LastModified),
+			update.SourceID, doc.URL, bodyHash,
+		)
+		if err != nil {
+			return saved, fmt.Errorf("failed to record check of document %s: %w", doc.URL, err)
+		}
+	}
+
+	return saved, nil
+}
+
+// GetDocumentState returns the validators and last check of the version of
+// an attachment URL checked last, nil if the URL was never fetched
+func (s *SQLiteStorage) GetDocumentState(ctx context.Context, sourceID, url string) (*DocumentState, error) {
+	query := `
+	SELECT COALESCE(etag, ''), COALESCE(last_modified, ''), COALESCE(checked_at, fetched_at)
+	FROM documents
+	WHERE source_id = ? AND url = ?
+	ORDER BY julianday(COALESCE(checked_at, fetched_at)) DESC, id DESC
+	LIMIT 1
+	`
+
+	var state DocumentState
+	var checkedAt string
+	err := s.db.QueryRowContext(ctx, query, sourceID, url).Scan(&state.ETag, &state.LastModified, &checkedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get document state: %w", err)
+	}
+
+	state.CheckedAt, err = time.Parse(time.RFC3339, checkedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse checked_at: %w", err)
+	}
+	return &state, nil
+}
+
+// GetSitemapURLs returns the URLs recorded from a source's sitemap, by location
+func (s *SQLiteStorage) GetSitemapURLs(ctx context.Context, sourceID string) (map[string]SitemapURL, error) {
+	query := `
+	SELECT loc, COALESCE(lastmod, ''), first_seen_at, last_seen_at,
+	       COALESCE(fetched_at, ''), COALESCE(fetched_lastmod, '')
+	FROM sitemap_urls
+	WHERE source_id = ?
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, sourceID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query sitemap URLs: %w", err)
+	}
+	defer rows.Close()
+
+	urls := make(map[string]SitemapURL)
+	for rows.Next() {
+		var u SitemapURL
+		var lastMod, firstSeenAt, lastSeenAt, fetchedAt, fetchedLastMod string
+		if err := rows.Scan(&u.Loc, &lastMod, &firstSeenAt, &lastSeenAt, &fetchedAt, &fetchedLastMod); err != nil {
+			return nil, fmt.Errorf("failed to scan sitemap URL: %w", err)
+		}
+
+		for _, field := range []struct {
+			value string
+			dest  *time.Time
+			name  string
+		}{
+			{lastMod, &u.LastMod, "lastmod"},
+			{firstSeenAt, &u.FirstSeenAt, "first_seen_at"},
+			{lastSeenAt, &u.LastSeenAt, "last_seen_at"},
+			{fetchedAt, &u.FetchedAt, "fetched_at"},
+			{fetchedLastMod, &u.FetchedLastMod, "fetched_lastmod"},
+		} {
+			if field.value == "" {
+				continue
+			}
+			if *field.dest, err = time.Parse(time.RFC3339, field.value); err != nil {
+				return nil, fmt.Errorf("failed to parse %s: %w", field.name, err)
+			}
+		}
+
+		urls[u.Loc] = u
+	}
+
+	return urls, rows.Err()
+}
+
+// SaveSitemapURLs records the URLs a source's sitemap currently lists. Known
+// URLs have their lastmod and last_seen_at refreshed.
+func (s *SQLiteStorage) SaveSitemapURLs(ctx context.Context, sourceID string, urls []SitemapURL, seenAt time.Time) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+	INSERT INTO sitemap_urls (source_id, loc, lastmod, first_seen_at, last_seen_at)
+	VALUES (?, ?, ?, ?, ?)
+	ON CONFLICT(source_id, loc) DO UPDATE SET
+		lastmod = excluded.lastmod,
+		last_seen_at = excluded.last_seen_at
+	`
+
+	seen := seenAt.UTC().Format(time.RFC3339)
+	for _, u := range urls {
+		if _, err := tx.ExecContext(ctx, query, sourceID, u.Loc, formatOptionalTime(u.LastMod), seen, seen); err != nil {
+			return fmt.Errorf("failed to save sitemap URL: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit sitemap URLs: %w", err)
+	}
+	return nil
+}
+
+// MarkSitemapURLFetched records that a sitemap URL was fetched at the given lastmod
+func (s *SQLiteStorage) MarkSitemapURLFetched(ctx context.Context, sourceID, loc string, lastMod, fetchedAt time.Time) error {
+	_, err := s.db.ExecContext(ctx,
+		`UPDATE sitemap_urls SET fetched_at = ?, fetched_lastmod = ? WHERE source_id = ? AND loc = ?`,
+		fetchedAt.UTC().Format(time.RFC3339), formatOptionalTime(lastMod), sourceID, loc,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to mark sitemap URL fetched: %w", err)
+	}
+	return nil
+}
+
+// PendingCrawlURLs returns the pages a source's crawl queued but has not
+// visited yet, shallowest first and in the order they were discovered
+func (s *SQLiteStorage) PendingCrawlURLs(ctx context.Context, sourceID string, limit int) ([]CrawlURL, error) {
+	query := `
+	SELECT url, depth, queued_at
+	FROM crawl_frontier
+	WHERE source_id = ? AND visited_at IS NULL
+	ORDER BY depth, id
+	LIMIT ?
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, sourceID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query crawl frontier: %w", err)
+	}
+	defer rows.Close()
+
+	var urls []CrawlURL
+	for rows.Next() {
+		var u CrawlURL
+		var queuedAt string
+		if err := rows.Scan(&u.URL, &u.Depth, &queuedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan crawl URL: %w", err)
+		}
+		if u.QueuedAt, err = time.Parse(time.RFC3339, queuedAt); err != nil {
+			return nil, fmt.Errorf("failed to parse queued_at: %w", err)
+		}
+		urls = append(urls, u)
+	}
+
+	return urls, rows.Err()
+}
+
+// QueueCrawlURLs adds pages to a source's crawl. Pages already queued or
+// visited in the current crawl are left as they are.
+func (s *SQLiteStorage) QueueCrawlURLs(ctx context.Context, sourceID string, urls []CrawlURL) error {
+	if len(urls) == 0 {
+		return nil
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+	INSERT INTO crawl_frontier (source_id, url, depth, queued_at)
+	VALUES (?, ?, ?, ?)
+	ON CONFLICT(source_id, url) DO NOTHING
+	`
+	for _, u := range urls {
+		if _, err := tx.ExecContext(ctx, query, sourceID, u.URL, u.Depth, u.QueuedAt.UTC().Format(time.RFC3339)); err != nil {
+			return fmt.Errorf("failed to queue crawl URL: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit crawl URLs: %w", err)
+	}
+	return nil
+}
+
+// MarkCrawlURLVisited records that a queued page of a crawl was fetched
+func (s *SQLiteStorage) MarkCrawlURLVisited(ctx context.Context, sourceID, url string, visitedAt time.Time) error {
+	_, err := s.db.ExecContext(ctx,
+		`UPDATE crawl_frontier SET visited_at = ? WHERE source_id = ? AND url = ?`,
+		visitedAt.UTC().Format(time.RFC3339), sourceID, url,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to mark crawl URL visited: %w", err)
+	}
+	return nil
+}
+
+// ResetCrawl clears a source's crawl frontier so the next crawl starts over
+func (s *SQLiteStorage) ResetCrawl(ctx context.Context, sourceID string) error {
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM crawl_frontier WHERE source_id = ?`, sourceID); err != nil {
+		return fmt.Errorf("failed to reset crawl: %w", err)
+	}
+	return nil
+}
+
+// formatOptionalTime formats t for storage, mapping the zero time to NULL
+func formatOptionalTime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
 // Close closes the database connection
 func (s *SQLiteStorage) Close() error {
 	if s.db != nil {
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+const (
+	// maxSummarySentences bounds how many sentences a summary keeps
+	maxSummarySentences = 3
+	// maxSummaryLen bounds a summary's length in runes
+	maxSummaryLen = 400
+)
+
+// stopWords are too common to say anything about a sentence's content
+var stopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true,
+	"be": true, "been": true, "by": true, "for": true, "from": true, "has": true,
+	"have": true, "in": true, "is": true, "it": true, "its": true, "of": true,
+	"on": true, "or": true, "shall": true, "that": true, "the": true, "this": true,
+	"to": true, "was": true, "were": true, "which": true, "will": true, "with": true,
+}
+
+// PageMetadata holds the descriptive metadata of an HTML page
+type PageMetadata struct {
+	Title       string
+	Description string
+}
+
+// pageMetadata reads a page's title and description, preferring OpenGraph
+// tags over <title> and <meta name="description">. Bodies that are not HTML
+// have no metadata.
+func pageMetadata(body []byte) PageMetadata {
+	var meta PageMetadata
+	if !isHTML(body) {
+		return meta
+	}
+
+	doc, err := html.Parse(bytes.NewReader(body))
+	if err != nil {
+		return meta
+	}
+
+	var title, ogTitle, description, ogDescription string
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			switch n.DataAtom {
+			case atom.Title:
+				if title == "" {
+					title = nodeText(n)
+				}
+			case atom.Meta:
+				content := attrValue(n, "content")
+				switch strings.ToLower(attrValue(n, "property") + attrValue(n, "name")) {
+				case "og:title":
+					ogTitle = content
+				case "og:description":
+					ogDescription = content
+				case "description":
+					description = content
+				}
+			case atom.Body:
+				// Metadata lives in <head>; an <svg><title> in the body is not the page title
+				return
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+
+	meta.Title = firstNonEmpty(ogTitle, title)
+	meta.Description = firstNonEmpty(ogDescription, description)
+	return meta
+}
+
+// attrValue returns the value of an element's attribute, or ""
+func attrValue(n *html.Node, key string) string {
+	for _, attr := range n.Attr {
+		if attr.Key == key {
+			return attr.Val
+		}
+	}
+	return ""
+}
+
+// firstNonEmpty returns the first value that is not blank, whitespace-collapsed
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v = strings.Join(strings.Fields(v), " "); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// contentType returns the declared Content-Type of a response, sniffing the
+// body when the server did not send one
+func contentType(header http.Header, body []byte) string {
+	if ct := header.Get("Content-Type"); ct != "" {
+		return ct
+	}
+	return http.DetectContentType(body)
+}
+
+// summarizeChange sets update.Summary to an extractive summary of the text
+// added since the source's previous version. For a first version, the page's
+// description or an extractive summary of its text is used instead.
+func summarizeChange(ctx context.Context, storage Storage, normalizer *Normalizer, update *Update) {
+	if !update.Success || len(update.Body) == 0 || update.Summary != "" {
+		return
+	}
+
+	newText := bodyText(normalizer, update.Body, update.SourceID)
+	if update.Text != "" {
+		newText = bodyText(normalizer, []byte(update.Text), update.SourceID)
+	}
+
+	previous, err := storage.GetPreviousUpdate(ctx, update.SourceID, update.URL, update.FetchedAt)
+	if err != nil {
+		log.Printf("[SUMMARY] Failed to find previous version of %s: %v", update.SourceID, err)
+	}
+	if previous != nil && previous.BodyHash != "" && previous.BodyHash != update.BodyHash {
+		oldText, err := versionText(ctx, storage, normalizer, previous)
+		if err == nil {
+			var added, removed []string
+			for _, block := range DiffText(oldText, newText).Blocks {
+				added = append(added, block.Added...)
+				removed = append(removed, block.Removed...)
+			}
+			if len(added) > 0 {
+				update.Summary = extractiveSummary(strings.Join(added, "\n"))
+			} else if len(removed) > 0 {
+				update.Summary = "Removed: " + extractiveSummary(strings.Join(removed, "\n"))
+			}
+			return
+		}
+		log.Printf("[SUMMARY] Could not load previous version of %s: %v", update.SourceID, err)
+	}
+
+	if description := pageMetadata(update.Body).Description; description != "" {
+		update.Summary = clipText(description, maxSummaryLen)
+		return
+	}
+	update.Summary = extractiveSummary(newText)
+}
+
+// extractiveSummary picks the sentences of text that best represent it,
+// scoring each by how frequent its content words are across the whole text.
+// Chosen sentences are returned in their original order.
+func extractiveSummary(text string) string {
+	sentences := splitSentences(text)
+	if len(sentences) == 0 {
+		return ""
+	}
+
+	freq := make(map[string]int)
+	for _, s := range sentences {
+		for _, w := range contentWords(s) {
+			freq[w]++
+		}
+	}
+
+	type scored struct {
+		index int
+		score float64
+	}
+	var candidates []scored
+	for i, s := range sentences {
+		words := contentWords(s)
+		if len(words) == 0 {
+			continue
+		}
+		total := 0
+		for _, w := range words {
+			total += freq[w]
+		}
+		// Dampen length so long sentences do not win on size alone
+		candidates = append(candidates, scored{i, float64(total) / math.Sqrt(float64(len(words)))})
+	}
+	if len(candidates) == 0 {
+		return clipText(strings.Join(sentences, " "), maxSummaryLen)
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].score > candidates[j].score
+	})
+	if len(candidates) > maxSummarySentences {
+		candidates = candidates[:maxSummarySentences]
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].index < candidates[j].index
+	})
+
+	chosen := make([]string, len(candidates))
+	for i, c := range candidates {
+		chosen[i] = sentences[c.index]
+	}
+	return clipText(strings.Join(chosen, " "), maxSummaryLen)
+}
+
+// splitSentences splits text into sentences at line breaks and at sentence
+// punctuation followed by whitespace
+func splitSentences(text string) []string {
+	var sentences []string
+	for _, line := range strings.Split(text, "\n") {
+		start := 0
+		runes := []rune(line)
+		for i, r := range runes {
+			if (r == '.' || r == '!' || r == '?') && i+1 < len(runes) && unicode.IsSpace(runes[i+1]) {
+				sentences = appendSentence(sentences, string(runes[start:i+1]))
+				start = i + 1
+			}
+		}
+		sentences = appendSentence(sentences, string(runes[start:]))
+	}
+	return sentences
+}
+
+// appendSentence appends s to sentences unless it is blank
+func appendSentence(sentences []string, s string) []string {
+	if s = strings.TrimSpace(s); s != "" {
+		sentences = append(sentences, s)
+	}
+	return sentences
+}
+
+// contentWords returns the lowercased words of s that are not stop words
+func contentWords(s string) []string {
+	var words []string
+	for _, w := range strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if len(w) > 1 && !stopWords[w] {
+			words = append(words, w)
+		}
+	}
+	return words
+}
+
+// clipText shortens s to at most max runes, marking the cut with an ellipsis
+func clipText(s string, max int) string {
+	runes := []rune(s)
+	if len(runes) <= max {
+		return s
+	}
+	return strings.TrimSpace(string(runes[:max-1])) + "…"
+}
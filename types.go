@@ -1,29 +1,247 @@
 package main
 
-import "time"
+import (
+	"regexp"
+	"time"
+)
 
 // Source defines a single authoritative source to scrape
 type Source struct {
 	ID         string
+	Name       string
+	Category   string
+	Type       string // SourceTypePage, SourceTypeFeed, SourceTypeSitemap or SourceTypeCrawl
 	URL        string
 	Cron       string
 	JSRendered bool
 	MaxRetries int
 	Timeout    time.Duration
+
+	// Response policy
+	AcceptedStatus  []int
+	MaxRedirects    int // 0 uses the default limit, negative disables redirects
+	Soft404Patterns []*regexp.Regexp
+
+	// Rendering options for JSRendered sources
+	WaitForSelector string
+	WaitNetworkIdle bool
+
+	// Normalizer strips noise before hashing; nil hashes the raw body
+	Normalizer *Normalizer
+
+	// Extractor splits listing pages into items; nil disables extraction
+	Extractor *Extractor
+
+	// Attachment links followed from the page; nil disables following
+	AttachmentSelector nodeSelector
+	MaxAttachments     int
+	AttachmentRecheck  time.Duration // how often known attachments are re-checked
+
+	// Sitemap sources track the listed URLs matching SitemapInclude (all if empty)
+	SitemapInclude    []*regexp.Regexp
+	MaxSitemapFetches int
+
+	// IgnoreRobots skips robots.txt checks, for sites that permit polling
+	IgnoreRobots bool
+
+	// Overlap decides what happens to a run due while the previous one is
+	// still going: OverlapSkip, OverlapQueue or OverlapCancel
+	Overlap string
+
+	// Severity ranks the source's notifications for routing: SeverityLow,
+	// SeverityMedium or SeverityHigh
+	Severity string
+
+	// Crawl sources follow links within CrawlScope up to CrawlMaxDepth hops
+	CrawlMaxDepth int
+	CrawlScope    string
+	CrawlInclude  []*regexp.Regexp
+	CrawlExclude  []*regexp.Regexp
+	MaxCrawlPages int
 }
 
 // Update represents a scraped update
 type Update struct {
 	SourceID    string
 	URL         string
+	StartedAt   time.Time // when work on this update began, set by ScraperManager
 	FetchedAt   time.Time
-	Hash        string
+	Hash        string // change-detection hash, over normalized content when configured
 	Body        []byte
 	StatusCode  int
 	Success     bool
-	RetryCount  int
+	RetryCount  int // retries performed before this outcome (attempts - 1)
 	ErrorDetail string
 	Title       string
 	Summary     string
 	ContentType string
+	BodyHash    string // raw SHA-256 of Body, the key of the stored body
+
+	// HTTP cache validators returned with this fetch
+	ETag         string
+	LastModified string
+	// NotModified is set when a conditional GET returned 304 or a feed had
+	// no new entries; such updates carry no body and are recorded as checks
+	// rather than stored
+	NotModified bool
+	// BlockedByRobots marks a failure caused by robots.txt rather than the site
+	BlockedByRobots bool
+	// Cancelled marks a run stopped by its overlap policy or by shutdown;
+	// such updates are recorded as runs but neither stored nor announced
+	Cancelled bool
+	// Reverted marks content the source's page had before and then changed from
+	Reverted bool
+
+	// Items extracted from the body for sources with an extraction spec
+	Items []Item
+
+	// Text extracted from a document body such as a PDF; Hash is computed
+	// over it, and TextHash is the key of the stored text
+	Text     string
+	TextHash string
+	// Documents are attachments fetched from links on the page
+	Documents []Document
+}
+
+// SourceState tracks per-source fetch metadata between runs
+type SourceState struct {
+	SourceID      string
+	ETag          string
+	LastModified  string
+	LastCheckedAt time.Time
+	LastChangedAt time.Time
+
+	// Scheduled runs skipped because the previous run was still going
+	SkippedRuns   int
+	LastSkippedAt time.Time
+}
+
+// Outbox entry statuses
+const (
+	OutboxPending = "pending" // waiting for its next attempt
+	OutboxSent    = "sent"
+	OutboxDead    = "dead" // given up on; `notify retry` makes it pending again
+)
+
+// DigestCursor records how far a recipient's digests in one mode have got
+type DigestCursor struct {
+	Recipient    string
+	Mode         string
+	LastUpdateID int64     // the last update covered; later ones go in the next digest
+	CoveredAt    time.Time // when the cursor last moved
+	LastSentAt   time.Time // zero until a digest is sent
+}
+
+// OutboxEntry is a notification to deliver on one channel
+type OutboxEntry struct {
+	ID            int64
+	EventID       string // Notification.Key, the same for every attempt
+	Event         string
+	Channel       string
+	SourceID      string
+	Payload       []byte // the Notification as JSON
+	Status        string
+	Attempts      int
+	NextAttemptAt time.Time
+	LastError     string
+	CreatedAt     time.Time
+	SentAt        time.Time
+}
+
+// DeadLetter is a notification a channel gave up delivering
+type DeadLetter struct {
+	Channel    string
+	EventID    string
+	Event      string
+	SourceID   string
+	Target     string // where delivery was attempted, such as the webhook URL
+	Payload    []byte
+	Attempts   int
+	StatusCode int // last response status, 0 if none was received
+	Error      string
+	FailedAt   time.Time
+}
+
+// Scrape run outcomes
+const (
+	RunChanged     = "changed"
+	RunReverted    = "reverted"
+	RunUnchanged   = "unchanged"
+	RunNotModified = "not_modified"
+	RunCancelled   = "cancelled"
+	RunError       = "error"
+)
+
+// ScrapeRun records a single fetch of a source and what came of it, whether
+// or not it produced new content
+type ScrapeRun struct {
+	SourceID   string
+	URL        string
+	StartedAt  time.Time
+	FinishedAt time.Time
+	Duration   time.Duration
+	StatusCode int
+	Bytes      int
+	Outcome    string // RunChanged, RunReverted, RunUnchanged, RunNotModified, RunCancelled or RunError
+	Attempts   int
+	Error      string
+}
+
+// Item is a single notice or circular extracted from a listing page,
+// identified within its source by a stable ItemID
+type Item struct {
+	SourceID    string
+	ItemID      string
+	Title       string
+	Link        string
+	Reference   string
+	DateText    string    // date as shown on the listing
+	PublishedAt time.Time // zero if DateText could not be parsed
+	FirstSeenAt time.Time
+	LastSeenAt  time.Time
+}
+
+// SitemapURL is a page listed in a source's sitemap
+type SitemapURL struct {
+	Loc            string
+	LastMod        time.Time // zero if the sitemap gives none
+	FirstSeenAt    time.Time
+	LastSeenAt     time.Time
+	FetchedAt      time.Time // zero until the page is first fetched
+	FetchedLastMod time.Time // the lastmod the page had when last fetched
+}
+
+// CrawlURL is a page queued by a crawl
+type CrawlURL struct {
+	URL       string
+	Depth     int // link hops from the source URL
+	QueuedAt  time.Time
+	VisitedAt time.Time // zero until the page is fetched
+}
+
+// Document is a file linked from a source's page, such as a circular's PDF
+type Document struct {
+	URL         string
+	ContentType string
+	Title       string
+	Body        []byte
+	BodyHash    string // raw SHA-256 of Body
+	Text        string // extracted text, empty if none could be extracted
+	TextHash    string
+	Pages       int
+
+	// HTTP cache validators returned with the file
+	ETag         string
+	LastModified string
+	// NotModified is set when a conditional re-check found the stored
+	// version current; such documents carry no body
+	NotModified bool
+}
+
+// DocumentState is what is known of the version of an attachment URL
+// checked last
+type DocumentState struct {
+	ETag         string
+	LastModified string
+	CheckedAt    time.Time
 }
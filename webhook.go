@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	// WebhookPayloadVersion is bumped when a field of the payload changes
+	// meaning or is removed; new fields may be added without a bump
+	WebhookPayloadVersion = 1
+
+	// Webhook request headers
+	WebhookEventHeader     = "X-LegiTrack-Event"
+	WebhookDeliveryHeader  = "X-LegiTrack-Delivery"
+	WebhookTimestampHeader = "X-LegiTrack-Timestamp"
+	WebhookSignatureHeader = "X-LegiTrack-Signature"
+
+	webhookUserAgent      = "LegiTrack-Webhook/1.0"
+	defaultWebhookTimeout = 10 * time.Second
+)
+
+// WebhookNotifier posts each notification as a signed JSON event
+type WebhookNotifier struct {
+	url    string
+	secret []byte
+	client *http.Client
+}
+
+// NewWebhookNotifier creates a webhook notifier from its configuration
+func NewWebhookNotifier(cfg WebhookConfig) (*WebhookNotifier, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid url %q", cfg.URL)
+	}
+	if cfg.Secret == "" {
+		return nil, errors.New("secret is required to sign events")
+	}
+
+	timeout := defaultWebhookTimeout
+	if d, err := time.ParseDuration(cfg.Timeout); err == nil && d > 0 {
+		timeout = d
+	}
+
+	return &WebhookNotifier{
+		url:    cfg.URL,
+		secret: []byte(cfg.Secret),
+		client: &http.Client{Timeout: timeout},
+	}, nil
+}
+
+// Name identifies the notifier in logs
+func (w *WebhookNotifier) Name() string {
+	return "webhook"
+}
+
+// Target is the URL events are posted to
+func (w *WebhookNotifier) Target() string {
+	return w.url
+}
+
+// Handles reports whether a notification is posted: all of them are
+func (w *WebhookNotifier) Handles(n Notification) bool {
+	return true
+}
+
+// Notify posts a notification as one signed delivery attempt. Errors are
+// classified like fetch errors, so the outbox retries only those that are
+// likely to be transient.
+func (w *WebhookNotifier) Notify(ctx context.Context, n Notification) error {
+	payload, err := json.Marshal(newWebhookEvent(n, time.Now()))
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+	return w.post(ctx, n, payload)
+}
+
+// post sends a signed event
+func (w *WebhookNotifier) post(ctx context.Context, n Notification, payload []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+
+	// Each attempt is signed afresh so its timestamp is current
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", webhookUserAgent)
+	req.Header.Set(WebhookEventHeader, n.Event)
+	req.Header.Set(WebhookDeliveryHeader, n.Key())
+	req.Header.Set(WebhookTimestampHeader, timestamp)
+	req.Header.Set(WebhookSignatureHeader, "sha256="+signWebhook(w.secret, timestamp, payload))
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return classifyError(err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return statusError(resp)
+	}
+	return nil
+}
+
+// signWebhook returns the hex HMAC-SHA256 of "<timestamp>.<body>". Receivers
+// compute the same over the raw body and reject stale timestamps, so a
+// captured request cannot be replayed later.
+func signWebhook(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookEvent is the JSON payload of a webhook delivery
+type webhookEvent struct {
+	Version   int             `json:"version"`
+	ID        string          `json:"id"`
+	Type      string          `json:"type"`
+	CreatedAt time.Time       `json:"created_at"`
+	Severity  string          `json:"severity"`
+	Source    webhookSource   `json:"source"`
+	Update    *webhookUpdate  `json:"update,omitempty"`
+	Failure   *webhookFailure `json:"failure,omitempty"`
+}
+
+type webhookSource struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Category string `json:"category,omitempty"`
+}
+
+type webhookUpdate struct {
+	URL         string         `json:"url"`
+	FetchedAt   time.Time      `json:"fetched_at"`
+	Hash        string         `json:"hash"`
+	StatusCode  int            `json:"status_code"`
+	ContentType string         `json:"content_type,omitempty"`
+	Title       string         `json:"title,omitempty"`
+	Summary     string         `json:"summary,omitempty"`
+	Reverted    bool           `json:"reverted"`
+	Change      *webhookChange `json:"change,omitempty"`
+}
+
+type webhookChange struct {
+	Added     int                  `json:"added"`
+	Removed   int                  `json:"removed"`
+	Blocks    []webhookChangeBlock `json:"blocks"`
+	Truncated bool                 `json:"truncated"`
+}
+
+type webhookChangeBlock struct {
+	Removed []string `json:"removed"`
+	Added   []string `json:"added"`
+}
+
+type webhookFailure struct {
+	URL             string    `json:"url"`
+	FetchedAt       time.Time `json:"fetched_at"`
+	StatusCode      int       `json:"status_code,omitempty"`
+	Attempts        int       `json:"attempts"`
+	Error           string    `json:"error"`
+	BlockedByRobots bool      `json:"blocked_by_robots"`
+}
+
+// newWebhookEvent builds the payload for a notification
+func newWebhookEvent(n Notification, now time.Time) webhookEvent {
+	event := webhookEvent{
+		Version:   WebhookPayloadVersion,
+		ID:        n.Key(),
+		Type:      n.Event,
+		CreatedAt: now.UTC(),
+		Severity:  n.Severity,
+		Source:    webhookSource{ID: n.SourceID, Name: n.SourceName, Category: n.Category},
+	}
+
+	if n.Event == EventSourceFailed {
+		event.Failure = &webhookFailure{
+			URL:             n.URL,
+			FetchedAt:       n.FetchedAt.UTC(),
+			StatusCode:      n.StatusCode,
+			Attempts:        n.RetryCount + 1,
+			Error:           n.ErrorDetail,
+			BlockedByRobots: n.BlockedByRobots,
+		}
+		return event
+	}
+
+	event.Update = &webhookUpdate{
+		URL:         n.URL,
+		FetchedAt:   n.FetchedAt.UTC(),
+		Hash:        n.Hash,
+		StatusCode:  n.StatusCode,
+		ContentType: n.ContentType,
+		Title:       n.Title,
+		Summary:     n.Summary,
+		Reverted:    n.Reverted,
+	}
+	if n.Change != nil {
+		change := &webhookChange{
+			Added:     n.Change.Added,
+			Removed:   n.Change.Removed,
+			Blocks:    []webhookChangeBlock{},
+			Truncated: n.Change.Truncated,
+		}
+		for _, block := range n.Change.Blocks {
+			change.Blocks = append(change.Blocks, webhookChangeBlock{Removed: block.Removed, Added: block.Added})
+		}
+		event.Update.Change = change
+	}
+	return event
+}